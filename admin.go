@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/admin"
+)
+
+// serveAdmin serves the admin HTTP API until the context is done, logging why
+// it stopped if it fails.
+func serveAdmin(ctx context.Context, addr string, manager *prototari.CommsManager) {
+	slog.Info("Serving the admin API", slog.String("addr", addr))
+
+	if err := admin.Serve(ctx, addr, manager); err != nil {
+		slog.Error("Serving the admin API failed", slog.Any("error", err))
+	}
+}
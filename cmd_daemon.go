@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/admin"
+	"github.com/angelsolaorbaiceta/prototari/prototari/control"
+)
+
+// daemonCmd runs the protocol until the process is interrupted, serving it to
+// the other applications in the computer through a Unix domain socket.
+func daemonCmd(args []string) error {
+	var (
+		fs        = flag.NewFlagSet("daemon", flag.ContinueOnError)
+		socket    = fs.String("socket", control.DefaultSocketPath, "path of the Unix domain socket to serve the local API on")
+		adminAddr = fs.String("admin-addr", "", "local address to serve the admin HTTP API on, like "+admin.DefaultAddr+" (disabled if empty)")
+		cf        = addConfigFlags(fs, slog.LevelInfo)
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := cf.Config()
+	if err != nil {
+		return err
+	}
+
+	server := control.NewServer(config.Logger)
+	config.Observer = prototari.MultiObserver(config.Observer, server)
+
+	listener, err := control.Listen(*socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	manager, err := prototari.MakeUDPManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := manager.Start(); err != nil {
+		return err
+	}
+
+	go reloadOnHangup(ctx, cf, manager)
+	if *adminAddr != "" {
+		go serveAdmin(ctx, *adminAddr, manager)
+	}
+
+	config.Logger.Info("Serving the local API", slog.String("socket", *socket))
+	if err := server.Serve(ctx, listener, manager); err != nil {
+		return err
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return manager.Shutdown(shutdownCtx)
+}
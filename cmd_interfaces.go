@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// interfacesCmd lists the network interfaces, marking the one whose private
+// IP the protocol picks.
+func interfacesCmd(args []string) error {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	privIP, broadIP, pickErr := prototari.GetPrivateIPAndBroadcastAddr()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tFLAGS\tADDRESSES")
+	for _, iface := range interfaces {
+		var (
+			addrs, _ = iface.Addrs()
+			names    = make([]string, 0, len(addrs))
+			mark     = ""
+		)
+
+		for _, addr := range addrs {
+			names = append(names, addr.String())
+
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(privIP) {
+				mark = "*"
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, iface.Name, iface.Flags, strings.Join(names, ", "))
+	}
+	tw.Flush()
+
+	fmt.Println()
+	if pickErr != nil {
+		fmt.Printf("The protocol can't run: %s\n", pickErr)
+	} else {
+		fmt.Printf("The protocol uses the private IP %s, broadcasting to %s (*)\n", privIP, broadIP)
+	}
+
+	return nil
+}
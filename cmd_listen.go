@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/control"
+)
+
+// listenCmd runs the protocol until the process is interrupted, printing the
+// messages received from the peers.
+func listenCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("listen", flag.ContinueOnError)
+		socket = fs.String("socket", "", "talk to the daemon listening on this socket instead of running the protocol")
+		cf     = addConfigFlags(fs, slog.LevelWarn)
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *socket != "" {
+		return daemonListen(*socket)
+	}
+
+	config, err := cf.Config()
+	if err != nil {
+		return err
+	}
+
+	manager, err := prototari.MakeUDPManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := manager.Start(); err != nil {
+		return err
+	}
+
+	for {
+		message, err := manager.Receive(ctx)
+		if errors.Is(err, context.Canceled) {
+			break
+		}
+
+		printMessage(message.From.IP, message.Payload)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return manager.Shutdown(shutdownCtx)
+}
+
+// daemonListen prints the messages received by the daemon until the process
+// is interrupted.
+func daemonListen(socket string) error {
+	client, err := control.Dial(socket)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	events, err := client.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		if event.Type == control.EventMessage {
+			printMessage(event.Peer.IP, event.Payload)
+		}
+	}
+
+	if ctx.Err() == nil {
+		return errors.New("connection closed by the daemon")
+	}
+
+	return nil
+}
+
+func printMessage(from net.IP, payload []byte) {
+	fmt.Printf("%s [%s] %s\n", time.Now().Format(time.TimeOnly), from, payload)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/control"
+)
+
+// defaultDiscoveryWait is the time the commands wait for the peers to be
+// discovered. It's two broadcast intervals, so that every computer in the
+// network has had the chance to broadcast at least once.
+const defaultDiscoveryWait = 10 * time.Second
+
+// peersCmd runs the protocol for a while and lists the discovered peers.
+func peersCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("peers", flag.ContinueOnError)
+		wait   = fs.Duration("wait", defaultDiscoveryWait, "time to discover peers before listing them")
+		socket = fs.String("socket", "", "talk to the daemon listening on this socket instead of running the protocol")
+		cf     = addConfigFlags(fs, slog.LevelWarn)
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *socket != "" {
+		return daemonPeers(*socket)
+	}
+
+	config, err := cf.Config()
+	if err != nil {
+		return err
+	}
+
+	manager, err := prototari.MakeUDPManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancelWait := context.WithTimeout(ctx, *wait)
+	defer cancelWait()
+
+	if err := manager.Start(); err != nil {
+		return err
+	}
+
+	peers := waitForPeers(ctx, manager, func([]prototari.Peer) bool { return false })
+	printPeers(os.Stdout, peers)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return manager.Shutdown(shutdownCtx)
+}
+
+// daemonPeers lists the peers registered by the daemon.
+func daemonPeers(socket string) error {
+	client, err := control.Dial(socket)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	daemonPeers, err := client.Peers(context.Background())
+	if err != nil {
+		return err
+	}
+
+	peers := make([]prototari.Peer, 0, len(daemonPeers))
+	for _, peer := range daemonPeers {
+		peers = append(peers, prototari.Peer{
+			IP:               peer.IP,
+			LastSeen:         peer.LastSeen,
+			MissedHeartbeats: peer.MissedHeartbeats,
+			State:            peer.State,
+			Link:             peer.Link,
+			ClockOffset:      peer.ClockOffset,
+			ClockUncertainty: peer.ClockUncertainty,
+		})
+	}
+	printPeers(os.Stdout, peers)
+
+	return nil
+}
+
+// waitForPeers keeps the latest registered peers until the done function
+// returns true for them or the context is done, and returns them.
+func waitForPeers(
+	ctx context.Context,
+	manager *prototari.CommsManager,
+	done func([]prototari.Peer) bool,
+) []prototari.Peer {
+	var peers []prototari.Peer
+
+	for {
+		select {
+		case <-ctx.Done():
+			return peers
+		case peers = <-manager.PeersCh():
+			if done(peers) {
+				return peers
+			}
+		}
+	}
+}
+
+// printPeers writes a table with the peers.
+func printPeers(w io.Writer, peers []prototari.Peer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tSTATE\tLAST SEEN\tMISSED HEARTBEATS\tRTT\tLOSS")
+	for _, peer := range peers {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s ago\t%d\t%s\t%.0f%%\n",
+			peer.IP,
+			peer.State,
+			time.Since(peer.LastSeen).Round(time.Second),
+			peer.MissedHeartbeats,
+			peer.Link.RTT.Round(10*time.Microsecond),
+			100*peer.Link.Loss,
+		)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/admin"
+)
+
+// shutdownTimeout is the time given to the disconnect messages to be sent
+// when leaving the network.
+const shutdownTimeout = 2 * time.Second
+
+// runCmd runs the protocol until the process is interrupted, logging the
+// registered peers every time they change.
+func runCmd(args []string) error {
+	var (
+		fs          = flag.NewFlagSet("run", flag.ContinueOnError)
+		metricsAddr = fs.String("metrics-addr", "", "local address to serve the Prometheus metrics on, like localhost:9100 (disabled if empty)")
+		adminAddr   = fs.String("admin-addr", "", "local address to serve the admin HTTP API on, like "+admin.DefaultAddr+" (disabled if empty)")
+		cf          = addConfigFlags(fs, slog.LevelInfo)
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	privIP, broadIP, err := prototari.GetPrivateIPAndBroadcastAddr()
+	if err != nil {
+		return err
+	}
+
+	config, err := cf.Config()
+	if err != nil {
+		return err
+	}
+
+	manager, err := prototari.MakeUDPManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	if *metricsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(*metricsAddr, manager.MetricsHandler())
+			log.Printf("Serving metrics failed: %s\n", err)
+		}()
+	}
+
+	log.Println("========================= [Pelotari] =========================")
+	log.Printf("Private IP: %s, Broadcast IP: %s\n", privIP, broadIP)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := manager.Start(); err != nil {
+		return err
+	}
+
+	go reloadOnHangup(ctx, cf, manager)
+	if *adminAddr != "" {
+		go serveAdmin(ctx, *adminAddr, manager)
+	}
+
+	log.Println("Pelotari protocol starting... Press CTRL+C to exit.")
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case peers := <-manager.PeersCh():
+			log.Println("----- [Peers] -----")
+			for _, peer := range peers {
+				log.Printf("\t> %s\n", peer.Address())
+			}
+		}
+	}
+
+	log.Println("Leaving the network...")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return manager.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/angelsolaorbaiceta/prototari/prototari/control"
+)
+
+// sendCmd runs the protocol until the target peer is discovered, sends it the
+// message and leaves the network.
+// When the target is "all", it waits for the peers to be discovered, and sends
+// the message to every one of them.
+func sendCmd(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("send", flag.ContinueOnError)
+		wait   = fs.Duration("wait", defaultDiscoveryWait, "maximum time to discover the peers")
+		socket = fs.String("socket", "", "talk to the daemon listening on this socket instead of running the protocol")
+		cf     = addConfigFlags(fs, slog.LevelWarn)
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 2 {
+		return errors.New("expected a peer IP (or all) and a message")
+	}
+
+	var (
+		target  = fs.Arg(0)
+		message = []byte(strings.Join(fs.Args()[1:], " "))
+		peerIP  net.IP
+	)
+
+	if target != "all" {
+		if peerIP = net.ParseIP(target); peerIP == nil {
+			return fmt.Errorf("invalid peer IP %q", target)
+		}
+	}
+
+	if *socket != "" {
+		return daemonSend(*socket, peerIP, message)
+	}
+
+	config, err := cf.Config()
+	if err != nil {
+		return err
+	}
+
+	manager, err := prototari.MakeUDPManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	waitCtx, cancelWait := context.WithTimeout(ctx, *wait)
+	defer cancelWait()
+
+	if err := manager.Start(); err != nil {
+		return err
+	}
+
+	// Sending to all peers waits for the whole discovery time, as there's no
+	// way of knowing how many peers there are
+	peers := waitForPeers(waitCtx, manager, func(peers []prototari.Peer) bool {
+		return peerIP != nil && slices.ContainsFunc(peers, func(peer prototari.Peer) bool {
+			return peer.IP.Equal(peerIP)
+		})
+	})
+
+	if peerIP == nil {
+		if len(peers) == 0 {
+			err = errors.New("no peers found")
+		} else {
+			err = manager.SendMessage(ctx, message)
+		}
+	} else {
+		err = manager.SendMessageTo(ctx, prototari.Peer{IP: peerIP}, message)
+		if errors.Is(err, prototari.ErrUnknownPeer) {
+			err = fmt.Errorf("peer %s not found", peerIP)
+		}
+	}
+
+	// Shutting down waits for the queued message to be sent
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	return errors.Join(err, manager.Shutdown(shutdownCtx))
+}
+
+// daemonSend has the daemon send the message to the peer with the given IP, or
+// to all its peers if the IP is nil.
+func daemonSend(socket string, peerIP net.IP, message []byte) error {
+	client, err := control.Dial(socket)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if peerIP == nil {
+		return client.SendMessage(ctx, message)
+	}
+
+	err = client.SendMessageTo(ctx, peerIP, message)
+	if errors.Is(err, prototari.ErrUnknownPeer) {
+		return fmt.Errorf("peer %s not found", peerIP)
+	}
+
+	return err
+}
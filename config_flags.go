@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// configFlags are the command line flags to set each Config parameter.
+type configFlags struct {
+	fs       *flag.FlagSet
+	path     string
+	config   prototari.Config
+	logLevel slog.Level
+}
+
+// addConfigFlags registers the Config flags in the flag set, using the
+// protocol defaults and the given log level as default values.
+func addConfigFlags(fs *flag.FlagSet, logLevel slog.Level) *configFlags {
+	cf := &configFlags{
+		fs:       fs,
+		config:   prototari.MakeDefaultConfig(),
+		logLevel: logLevel,
+	}
+
+	fs.StringVar(&cf.path, "config", "", "configuration file (JSON, or name = value lines); the PELOTARI_* environment variables and the flags override it")
+	registerConfigFlags(fs, &cf.config)
+	fs.TextVar(&cf.logLevel, "log-level", cf.logLevel, "minimum level of the protocol logs: debug, info, warn or error")
+
+	return cf
+}
+
+// registerConfigFlags registers a flag for each parameter of the config.
+func registerConfigFlags(fs *flag.FlagSet, config *prototari.Config) {
+	fs.IntVar(&config.MaxPeers, "max-peers", config.MaxPeers, "maximum number of peers to register")
+	fs.DurationVar(&config.BroadcastInterval, "broadcast-interval", config.BroadcastInterval, "time between discovery broadcasts, once the fast ones are over")
+	fs.DurationVar(&config.DiscoveryFastInterval, "discovery-fast-interval", config.DiscoveryFastInterval, "time between the first discovery broadcasts, doubling up to broadcast-interval (0 disables it)")
+	fs.IntVar(&config.DiscoveryFastBroadcasts, "discovery-fast-broadcasts", config.DiscoveryFastBroadcasts, "discovery broadcasts sent every discovery-fast-interval before it starts doubling")
+	fs.Float64Var(&config.DiscoveryJitter, "discovery-jitter", config.DiscoveryJitter, "fraction of the interval between broadcasts each one is randomly shifted by (0 disables it)")
+	fs.DurationVar(&config.DiscoveryResponseDelay, "discovery-response-delay", config.DiscoveryResponseDelay, "longest random wait before answering a discovery broadcast (0 answers right away)")
+	fs.IntVar(&config.DiscoveryNearlyFull, "discovery-nearly-full", config.DiscoveryNearlyFull, "free slots below which broadcasters are answered less often (0 only skips full ones)")
+	fs.DurationVar(&config.InactivePeerTime, "inactive-peer-time", config.InactivePeerTime, "time without hearing from a peer before sending it a heartbeat")
+	fs.DurationVar(&config.HeartbeatMaxWait, "heartbeat-max-wait", config.HeartbeatMaxWait, "time a peer has to answer a heartbeat")
+	fs.IntVar(&config.MaxMissedHeartbeats, "max-missed-heartbeats", config.MaxMissedHeartbeats, "heartbeats in a row a peer can miss before it's evicted")
+	fs.IntVar(&config.IndirectProbes, "indirect-probes", config.IndirectProbes, "peers asked to probe a peer that didn't answer a heartbeat (0 disables it)")
+	fs.Var(&failureDetectorValue{detector: &config.FailureDetector}, "failure-detector", "how to decide peers are down: threshold (default) or phi-accrual")
+	fs.IntVar(&config.BroadcastPort, "broadcast-port", config.BroadcastPort, "port the discovery messages are sent to")
+	fs.IntVar(&config.UnicastPort, "unicast-port", config.UnicastPort, "port the messages between peers are sent to")
+	fs.IntVar(&config.SendQueueCapacity, "send-queue-capacity", config.SendQueueCapacity, "maximum number of messages of each priority queued for a peer")
+	fs.TextVar(&config.SendQueueOverflow, "send-queue-overflow", config.SendQueueOverflow, "what to do with messages sent to a full queue: block, drop-oldest, drop-newest or error")
+	fs.TextVar(&config.EvictionPolicy, "eviction-policy", config.EvictionPolicy, "which peers to evict when max-peers is reduced: least-recently-seen or newest")
+	fs.TextVar(&config.FullPolicy, "full-policy", config.FullPolicy, "what to do with new computers when max-peers are registered: refuse, evict-least-recently-seen or evict-worst-link (evict-lowest-priority needs a Priority function, which only the library can set)")
+	fs.TextVar(&config.PinnedPeers, "pinned-peers", config.PinnedPeers, "IPs of the peers with a reserved slot, separated by commas")
+
+	rateLimitVar(fs, &config.RateLimits.PeerPackets, "peer-packets", "packets per second sent to each peer")
+	rateLimitVar(fs, &config.RateLimits.PeerBytes, "peer-bytes", "bytes per second sent to each peer")
+	rateLimitVar(fs, &config.RateLimits.GlobalPackets, "global-packets", "packets per second sent to all peers")
+	rateLimitVar(fs, &config.RateLimits.GlobalBytes, "global-bytes", "bytes per second sent to all peers")
+	rateLimitVar(fs, &config.RateLimits.InboundPackets, "inbound-packets", "packets per second accepted from each IP")
+}
+
+// rateLimitVar registers the flags of a rate limit: <name>-rate and
+// <name>-burst.
+func rateLimitVar(fs *flag.FlagSet, limit *prototari.RateLimit, name, what string) {
+	fs.Float64Var(&limit.Rate, name+"-rate", limit.Rate, "limit of "+what+" (0 disables it)")
+	fs.IntVar(&limit.Burst, name+"-burst", limit.Burst, "burst allowed over the limit of "+what)
+}
+
+// failureDetectorValue is the flag value selecting the failure detector by
+// name. It keeps the name, so that the flag can be applied again.
+type failureDetectorValue struct {
+	detector *prototari.FailureDetector
+	name     string
+}
+
+func (v *failureDetectorValue) String() string {
+	return v.name
+}
+
+func (v *failureDetectorValue) Set(name string) (err error) {
+	if *v.detector, err = prototari.FailureDetectorNamed(name); err != nil {
+		return err
+	}
+
+	v.name = name
+	return nil
+}
+
+// Config returns the configuration loaded from the file and the environment,
+// with the flags set in the command line applied on top, logging to the
+// standard error.
+func (cf *configFlags) Config() (prototari.Config, error) {
+	config := prototari.MakeDefaultConfig()
+	if cf.path != "" {
+		if err := config.LoadFile(cf.path); err != nil {
+			return prototari.Config{}, err
+		}
+	}
+	if err := config.LoadEnv(); err != nil {
+		return prototari.Config{}, err
+	}
+
+	// The flags set in the command line are applied again, this time to the
+	// loaded configuration
+	var (
+		loadedFs = flag.NewFlagSet("", flag.ContinueOnError)
+		setErr   error
+	)
+	registerConfigFlags(loadedFs, &config)
+	cf.fs.Visit(func(f *flag.Flag) {
+		if loadedFs.Lookup(f.Name) != nil && setErr == nil {
+			setErr = loadedFs.Set(f.Name, f.Value.String())
+		}
+	})
+	if setErr != nil {
+		return prototari.Config{}, setErr
+	}
+
+	if err := config.Validate(); err != nil {
+		return prototari.Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	config.Logger = slog.New(slog.NewTextHandler(
+		os.Stderr,
+		&slog.HandlerOptions{Level: cf.logLevel},
+	))
+
+	return config, nil
+}
+
+// reloadOnHangup loads the configuration again and applies it to the running
+// manager every time the process receives SIGHUP, until the context is done.
+func reloadOnHangup(ctx context.Context, cf *configFlags, manager *prototari.CommsManager) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		}
+
+		config, err := cf.Config()
+		if err == nil {
+			err = manager.UpdateConfig(config)
+		}
+		if err != nil {
+			slog.Error("Reloading the configuration failed", slog.Any("error", err))
+		}
+	}
+}
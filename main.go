@@ -1,54 +1,94 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"sort"
 )
 
+// A command is one of the pelotari CLI subcommands.
+type command struct {
+	// usage is the command's arguments, as shown in the help.
+	usage string
+	// summary is a one line description of what the command does.
+	summary string
+	// run executes the command with the arguments after its name.
+	run func(args []string) error
+}
+
+var commands = map[string]command{
+	"run": {
+		usage:   "run [flags]",
+		summary: "Run the protocol, logging the peers as they join and leave",
+		run:     runCmd,
+	},
+	"peers": {
+		usage:   "peers [flags]",
+		summary: "Discover the peers for a while and list them",
+		run:     peersCmd,
+	},
+	"send": {
+		usage:   "send [flags] <peer IP|all> <message>",
+		summary: "Send a message to a peer, or to all peers",
+		run:     sendCmd,
+	},
+	"listen": {
+		usage:   "listen [flags]",
+		summary: "Print the messages received from the peers",
+		run:     listenCmd,
+	},
+	"daemon": {
+		usage:   "daemon [flags]",
+		summary: "Run the protocol, serving it to the local applications",
+		run:     daemonCmd,
+	},
+	"interfaces": {
+		usage:   "interfaces",
+		summary: "Show the network interfaces and which one the protocol uses",
+		run:     interfacesCmd,
+	},
+}
+
 func main() {
-	var (
-		config               = prototari.MakeDefaultConfig()
-		manager              = prototari.MakeUDPManager(config)
-		sigchan              = make(chan os.Signal, 1)
-		privIP, broadIP, err = prototari.GetPrivateIPAndBroadcastAddr()
-		peersCh              = manager.PeersCh()
-	)
-
-	if err != nil {
-		panic(err)
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
 	}
-	log.Println("========================= [Pelotari] =========================")
-	log.Printf("Private IP: %s, Broadcast IP: %s\n", privIP, broadIP)
-
-	manager.Start()
-	defer func() {
-		log.Println("Defer function: calling manger.Close()...")
-		manager.Close()
-		log.Println("Done. Bye!")
-	}()
-
-	log.Println("Pelotari protocol starting... Press CTRL+C to exit.")
-
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
-
-loop:
-	for {
-		select {
-		case <-sigchan:
-			// User pressed CTRL+C. Exit!
-			log.Println("Closing connections...")
-			break loop
-		case peers := <-peersCh:
-			log.Println("----- [Peers] -----")
-			for _, peer := range peers {
-				log.Printf("\t> %s\n", peer.Address())
-			}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
 		}
+
+		fmt.Fprintf(os.Stderr, "pelotari %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: pelotari <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", commands[name].usage, commands[name].summary)
 	}
 
-	log.Println("Closing connections...")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'pelotari <command> -h' to see the command's flags.")
 }
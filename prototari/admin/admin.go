@@ -0,0 +1,315 @@
+// Package admin implements an HTTP/JSON API to inspect and control a running
+// CommsManager, meant for debugging in the field:
+//
+//	GET  /self                  this computer's addresses and interface
+//	GET  /peers                 the registered peers
+//	GET  /peers/{ip}            a registered peer
+//	GET  /config                the current configuration
+//	GET  /metrics               the metrics, in the Prometheus text format
+//	POST /peers/{ip}/disconnect evict a peer
+//	POST /peers/{ip}/ping       measure the round-trip time to a peer
+//	POST /discover              broadcast the discovery message, after a short random delay
+//	POST /send                  send a message: {"to": "192.168.0.20", "message": "kaixo"}
+//
+// The API has no authentication, so it's bound to localhost by default. The
+// POST requests must have the application/json content type, and Serve refuses
+// the requests for other hosts, so that the web pages the operator visits
+// can't use the API through their browser.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// DefaultAddr is the address the API is served on when none is given.
+const DefaultAddr = "localhost:21452"
+
+// shutdownTimeout is the time given to the ongoing requests to finish when the
+// server is stopped.
+const shutdownTimeout = 2 * time.Second
+
+// maxRequestBytes is the largest request body accepted.
+const maxRequestBytes = 128 << 10
+
+// A Manager is the part of the CommsManager the API exposes.
+type Manager interface {
+	Self() prototari.LocalNode
+	Peers() []prototari.Peer
+	Peer(IP net.IP) (prototari.Peer, bool)
+	Config() prototari.Config
+	MetricsHandler() http.Handler
+	Disconnect(IP net.IP) error
+	Ping(ctx context.Context, peer prototari.Peer) (time.Duration, error)
+	Discover() error
+	SendMessage(ctx context.Context, payload []byte) error
+	SendMessageTo(ctx context.Context, peer prototari.Peer, payload []byte) error
+}
+
+// NewHandler returns the API's handler.
+func NewHandler(manager Manager) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /self", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, manager.Self())
+	})
+
+	mux.HandleFunc("GET /peers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, manager.Peers())
+	})
+
+	mux.HandleFunc("GET /peers/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		IP := net.ParseIP(r.PathValue("ip"))
+		if IP == nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid IP"))
+			return
+		}
+
+		peer, ok := manager.Peer(IP)
+		if !ok {
+			writeError(w, http.StatusNotFound, prototari.ErrUnknownPeer)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, peer)
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, makeConfigView(manager.Config()))
+	})
+
+	mux.Handle("GET /metrics", manager.MetricsHandler())
+
+	mux.HandleFunc("POST /peers/{ip}/disconnect", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		IP := net.ParseIP(r.PathValue("ip"))
+		if IP == nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid IP"))
+			return
+		}
+
+		writeResult(w, manager.Disconnect(IP))
+	}))
+
+	mux.HandleFunc("POST /peers/{ip}/ping", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		IP := net.ParseIP(r.PathValue("ip"))
+		if IP == nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid IP"))
+			return
+		}
+
+		rtt, err := manager.Ping(r.Context(), prototari.Peer{IP: IP})
+		if err != nil {
+			writeResult(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"rtt": rtt.String()})
+	}))
+
+	mux.HandleFunc("POST /discover", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		writeResult(w, manager.Discover())
+	}))
+
+	mux.HandleFunc("POST /send", requireJSON(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			// To is the IP of the peer, or empty to send to all peers
+			To      string `json:"to"`
+			Message string `json:"message"`
+		}
+		body := http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.To == "" {
+			writeResult(w, manager.SendMessage(r.Context(), []byte(req.Message)))
+			return
+		}
+
+		IP := net.ParseIP(req.To)
+		if IP == nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid IP"))
+			return
+		}
+
+		writeResult(w, manager.SendMessageTo(r.Context(), prototari.Peer{IP: IP}, []byte(req.Message)))
+	}))
+
+	return mux
+}
+
+// Serve serves the API on the given address, or DefaultAddr if empty, until
+// the context is done.
+func Serve(ctx context.Context, addr string, manager Manager) error {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           checkHost(addr, NewHandler(manager)),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// requireJSON refuses the requests without the application/json content type.
+// The browsers don't send it to other sites without asking them first, as they
+// do with forms, so the web pages can't make the operator's browser change the
+// state of the protocol.
+func requireJSON(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("the content type must be application/json"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// checkHost refuses the requests for a host other than the one in the address
+// the API is served on, localhost or an IP. Otherwise, a web page could reach
+// the API by resolving its own name to the API's IP (DNS rebinding).
+func checkHost(addr string, handler http.Handler) http.Handler {
+	servedHost, _, _ := net.SplitHostPort(addr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if host != servedHost && host != "localhost" && net.ParseIP(strings.Trim(host, "[]")) == nil {
+			writeError(w, http.StatusForbidden, fmt.Errorf("unexpected host %q", r.Host))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// configView is the configuration as shown by the API, with the parameters
+// named as in the configuration files.
+type configView struct {
+	MaxPeers                int                      `json:"max-peers"`
+	BroadcastInterval       string                   `json:"broadcast-interval"`
+	DiscoveryFastInterval   string                   `json:"discovery-fast-interval"`
+	DiscoveryFastBroadcasts int                      `json:"discovery-fast-broadcasts"`
+	DiscoveryJitter         float64                  `json:"discovery-jitter"`
+	DiscoveryResponseDelay  string                   `json:"discovery-response-delay"`
+	DiscoveryNearlyFull     int                      `json:"discovery-nearly-full"`
+	InactivePeerTime        string                   `json:"inactive-peer-time"`
+	HeartbeatMaxWait        string                   `json:"heartbeat-max-wait"`
+	MaxMissedHeartbeats     int                      `json:"max-missed-heartbeats"`
+	IndirectProbes          int                      `json:"indirect-probes"`
+	FailureDetector         string                   `json:"failure-detector"`
+	BroadcastPort           int                      `json:"broadcast-port"`
+	UnicastPort             int                      `json:"unicast-port"`
+	SendQueueCapacity       int                      `json:"send-queue-capacity"`
+	SendQueueOverflow       prototari.OverflowPolicy `json:"send-queue-overflow"`
+	EvictionPolicy          prototari.EvictionPolicy `json:"eviction-policy"`
+	FullPolicy              prototari.FullPolicy     `json:"full-policy"`
+	Priorities              bool                     `json:"priorities"`
+	PinnedPeers             prototari.IPList         `json:"pinned-peers"`
+	AdmissionFilter         bool                     `json:"admission-filter"`
+	RateLimits              prototari.RateLimits     `json:"rate-limits"`
+}
+
+func makeConfigView(config prototari.Config) configView {
+	return configView{
+		MaxPeers:                config.MaxPeers,
+		BroadcastInterval:       config.BroadcastInterval.String(),
+		DiscoveryFastInterval:   config.DiscoveryFastInterval.String(),
+		DiscoveryFastBroadcasts: config.DiscoveryFastBroadcasts,
+		DiscoveryJitter:         config.DiscoveryJitter,
+		DiscoveryResponseDelay:  config.DiscoveryResponseDelay.String(),
+		DiscoveryNearlyFull:     config.DiscoveryNearlyFull,
+		InactivePeerTime:        config.InactivePeerTime.String(),
+		HeartbeatMaxWait:        config.HeartbeatMaxWait.String(),
+		MaxMissedHeartbeats:     config.MaxMissedHeartbeats,
+		IndirectProbes:          config.IndirectProbes,
+		FailureDetector:         detectorName(config.FailureDetector),
+		BroadcastPort:           config.BroadcastPort,
+		UnicastPort:             config.UnicastPort,
+		SendQueueCapacity:       config.SendQueueCapacity,
+		SendQueueOverflow:       config.SendQueueOverflow,
+		EvictionPolicy:          config.EvictionPolicy,
+		FullPolicy:              config.FullPolicy,
+		Priorities:              config.Priority != nil,
+		PinnedPeers:             config.PinnedPeers,
+		AdmissionFilter:         config.Admit != nil,
+		RateLimits:              config.RateLimits,
+	}
+}
+
+// detectorName returns the name of a failure detector: "threshold" for the
+// default one, the name the detector gives itself, or "custom".
+func detectorName(detector prototari.FailureDetector) string {
+	if detector == nil {
+		return "threshold"
+	}
+	if stringer, ok := detector.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+
+	return "custom"
+}
+
+// writeResult writes the outcome of an operation: no content if it succeeded,
+// or the error with the status matching it.
+func writeResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, prototari.ErrUnknownPeer):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, prototari.ErrNotRunning), errors.Is(err, prototari.ErrQueueClosed):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, prototari.ErrQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, prototari.ErrNoReply):
+		writeError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,231 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeManager struct {
+	peers        []prototari.Peer
+	disconnected []net.IP
+	discovered   int
+	sent         map[string]string
+}
+
+func (m *fakeManager) Self() prototari.LocalNode {
+	return prototari.LocalNode{IP: net.ParseIP("192.168.0.10"), Interface: "eth0"}
+}
+
+func (m *fakeManager) Peers() []prototari.Peer {
+	return m.peers
+}
+
+func (m *fakeManager) Peer(IP net.IP) (prototari.Peer, bool) {
+	if m.peers[0].IP.Equal(IP) {
+		return m.peers[0], true
+	}
+
+	return prototari.Peer{}, false
+}
+
+func (m *fakeManager) Config() prototari.Config {
+	return prototari.MakeDefaultConfig()
+}
+
+func (m *fakeManager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pelotari_peers 1\n")
+	})
+}
+
+func (m *fakeManager) Disconnect(IP net.IP) error {
+	if !m.peers[0].IP.Equal(IP) {
+		return prototari.ErrUnknownPeer
+	}
+
+	m.disconnected = append(m.disconnected, IP)
+	return nil
+}
+
+func (m *fakeManager) Ping(ctx context.Context, peer prototari.Peer) (time.Duration, error) {
+	if !m.peers[0].Equal(peer) {
+		return 0, prototari.ErrUnknownPeer
+	}
+
+	return 12 * time.Millisecond, nil
+}
+
+func (m *fakeManager) Discover() error {
+	m.discovered++
+	return nil
+}
+
+func (m *fakeManager) SendMessage(ctx context.Context, payload []byte) error {
+	m.sent["all"] = string(payload)
+	return nil
+}
+
+func (m *fakeManager) SendMessageTo(ctx context.Context, peer prototari.Peer, payload []byte) error {
+	if !m.peers[0].Equal(peer) {
+		return prototari.ErrUnknownPeer
+	}
+
+	m.sent[peer.IP.String()] = string(payload)
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	var (
+		peerIP  = net.ParseIP("192.168.0.20")
+		manager = &fakeManager{
+			peers: []prototari.Peer{prototari.MakePeer(peerIP)},
+			sent:  make(map[string]string),
+		}
+		server = httptest.NewServer(NewHandler(manager))
+		do     = func(method, path, body string) *http.Response {
+			req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+			assert.Nil(t, err)
+			if method == "POST" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			t.Cleanup(func() { res.Body.Close() })
+
+			return res
+		}
+	)
+	defer server.Close()
+
+	t.Run("GET /peers", func(t *testing.T) {
+		res := do("GET", "/peers", "")
+
+		var peers []prototari.Peer
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&peers))
+		if assert.Len(t, peers, 1) {
+			assert.True(t, peerIP.Equal(peers[0].IP))
+		}
+	})
+
+	t.Run("GET /peers/{ip}", func(t *testing.T) {
+		res := do("GET", "/peers/192.168.0.20", "")
+
+		var peer prototari.Peer
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&peer))
+		assert.True(t, peerIP.Equal(peer.IP))
+
+		assert.Equal(t, http.StatusNotFound, do("GET", "/peers/192.168.0.30", "").StatusCode)
+	})
+
+	t.Run("GET /self", func(t *testing.T) {
+		res := do("GET", "/self", "")
+
+		var self prototari.LocalNode
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&self))
+		assert.Equal(t, "eth0", self.Interface)
+	})
+
+	t.Run("GET /config", func(t *testing.T) {
+		res := do("GET", "/config", "")
+
+		var config map[string]any
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&config))
+		assert.Equal(t, "5s", config["broadcast-interval"])
+		assert.Equal(t, "block", config["send-queue-overflow"])
+	})
+
+	t.Run("GET /metrics", func(t *testing.T) {
+		res := do("GET", "/metrics", "")
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, "pelotari_peers 1\n", string(body))
+	})
+
+	t.Run("POST /peers/{ip}/disconnect", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do("POST", "/peers/192.168.0.20/disconnect", "").StatusCode)
+		assert.Equal(t, []net.IP{peerIP}, manager.disconnected)
+
+		assert.Equal(t, http.StatusNotFound, do("POST", "/peers/192.168.0.30/disconnect", "").StatusCode)
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/peers/nobody/disconnect", "").StatusCode)
+	})
+
+	t.Run("POST /peers/{ip}/ping", func(t *testing.T) {
+		res := do("POST", "/peers/192.168.0.20/ping", "")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		var body map[string]string
+		json.NewDecoder(res.Body).Decode(&body)
+		assert.Equal(t, "12ms", body["rtt"])
+
+		assert.Equal(t, http.StatusNotFound, do("POST", "/peers/192.168.0.30/ping", "").StatusCode)
+	})
+
+	t.Run("POST /discover", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do("POST", "/discover", "").StatusCode)
+		assert.Equal(t, 1, manager.discovered)
+	})
+
+	t.Run("POST /send", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do("POST", "/send", `{"message": "kaixo"}`).StatusCode)
+		assert.Equal(t, http.StatusNoContent, do("POST", "/send", `{"to": "192.168.0.20", "message": "aupa"}`).StatusCode)
+		assert.Equal(t, map[string]string{"all": "kaixo", "192.168.0.20": "aupa"}, manager.sent)
+
+		assert.Equal(t, http.StatusNotFound, do("POST", "/send", `{"to": "192.168.0.30", "message": "aupa"}`).StatusCode)
+	})
+
+	t.Run("Wrong method", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, do("POST", "/peers", "").StatusCode)
+	})
+
+	t.Run("POST requests other than JSON are refused", func(t *testing.T) {
+		res, err := http.PostForm(server.URL+"/peers/192.168.0.20/disconnect", nil)
+		assert.Nil(t, err)
+		res.Body.Close()
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+		assert.Len(t, manager.disconnected, 1)
+	})
+
+	t.Run("Too large messages are refused", func(t *testing.T) {
+		message := strings.Repeat("a", maxRequestBytes)
+
+		res := do("POST", "/send", `{"message": "`+message+`"}`)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	})
+}
+
+func TestCheckHost(t *testing.T) {
+	var (
+		handler = checkHost("localhost:21452", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		status = func(host string) int {
+			req := httptest.NewRequest("GET", "/peers", nil)
+			req.Host = host
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+	)
+
+	assert.Equal(t, http.StatusNoContent, status("localhost:21452"))
+	assert.Equal(t, http.StatusNoContent, status("127.0.0.1:21452"))
+	assert.Equal(t, http.StatusNoContent, status("[::1]:21452"))
+	assert.Equal(t, http.StatusForbidden, status("attacker.example:21452"))
+}
@@ -0,0 +1,79 @@
+package prototari
+
+import (
+	"strconv"
+	"time"
+)
+
+// clockFilterSize is the number of clock samples kept per peer. The estimation
+// uses the one with the shortest round trip, as NTP does.
+const clockFilterSize = 8
+
+// A clockSample is the clock offset of a peer measured in a heartbeat's round
+// trip, and the time the round trip spent on the network.
+type clockSample struct {
+	offset time.Duration
+	delay  time.Duration
+}
+
+// A clockFilter keeps the latest clock samples of a peer.
+type clockFilter struct {
+	samples [clockFilterSize]clockSample
+	n, next int
+}
+
+// add adds a sample and returns the one with the shortest delay, whose offset
+// is the least distorted by asymmetric network delays.
+func (f *clockFilter) add(sample clockSample) clockSample {
+	f.samples[f.next] = sample
+	f.next = (f.next + 1) % clockFilterSize
+	f.n = min(f.n+1, clockFilterSize)
+
+	best := f.samples[0]
+	for _, sample := range f.samples[1:f.n] {
+		if sample.delay < best.delay {
+			best = sample
+		}
+	}
+
+	return best
+}
+
+// clockOffset computes the clock offset of a peer and the network delay from
+// the four timestamps of a heartbeat's round trip: sent and answered in the
+// local clock, received and replied in the peer's clock.
+func clockOffset(sent, received, replied, answered time.Time) clockSample {
+	return clockSample{
+		offset: (received.Sub(sent) + replied.Sub(answered)) / 2,
+		delay:  answered.Sub(sent) - replied.Sub(received),
+	}
+}
+
+// observeClock adds the timestamps of a heartbeat's round trip to the peer's
+// clock offset estimation.
+func (p *Peer) observeClock(sent, received, replied, answered time.Time) {
+	best := p.clock.add(clockOffset(sent, received, replied, answered))
+	p.ClockOffset, p.ClockUncertainty = best.offset, max(best.delay/2, 0)
+}
+
+// LocalTime converts a time read from the peer's clock to the local clock,
+// using the estimated ClockOffset.
+func (p Peer) LocalTime(t time.Time) time.Time {
+	return t.Add(-p.ClockOffset)
+}
+
+// formatTimestamp formats a time for the heartbeat replies, as nanoseconds
+// since the Unix epoch.
+func formatTimestamp(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// parseTimestamp parses a time formatted with formatTimestamp.
+func parseTimestamp(s string) (time.Time, bool) {
+	nanos, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, nanos), true
+}
@@ -0,0 +1,146 @@
+package prototari
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockOffset(t *testing.T) {
+	var (
+		sent   = time.Now()
+		offset = time.Hour
+	)
+
+	t.Run("The offset is exact with symmetric delays", func(t *testing.T) {
+		var (
+			received = sent.Add(offset + 10*time.Millisecond)
+			replied  = received.Add(time.Millisecond)
+			answered = sent.Add(21 * time.Millisecond)
+			sample   = clockOffset(sent, received, replied, answered)
+		)
+
+		assert.Equal(t, offset, sample.offset)
+		assert.Equal(t, 20*time.Millisecond, sample.delay)
+	})
+
+	t.Run("The peers keep the sample with the shortest delay", func(t *testing.T) {
+		var peer Peer
+		// 10ms there, 30ms back
+		peer.observeClock(sent, sent.Add(offset+10*time.Millisecond), sent.Add(offset+10*time.Millisecond), sent.Add(40*time.Millisecond))
+		assert.Equal(t, offset-10*time.Millisecond, peer.ClockOffset)
+		assert.Equal(t, 20*time.Millisecond, peer.ClockUncertainty)
+
+		// 2ms there, 2ms back
+		peer.observeClock(sent, sent.Add(offset+2*time.Millisecond), sent.Add(offset+2*time.Millisecond), sent.Add(4*time.Millisecond))
+		assert.Equal(t, offset, peer.ClockOffset)
+		assert.Equal(t, 2*time.Millisecond, peer.ClockUncertainty)
+
+		// 50ms there, 10ms back
+		peer.observeClock(sent, sent.Add(offset+50*time.Millisecond), sent.Add(offset+50*time.Millisecond), sent.Add(60*time.Millisecond))
+		assert.Equal(t, offset, peer.ClockOffset)
+	})
+
+	t.Run("The peer's times are converted to the local clock", func(t *testing.T) {
+		peer := Peer{ClockOffset: offset}
+		assert.Equal(t, sent, peer.LocalTime(sent.Add(offset)))
+	})
+
+	t.Run("The heartbeat replies carry the timestamps", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			peerAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+			readCh    = make(chan fakeMsgRecord)
+			writeCh   = make(chan fakeMsgRecord, 16)
+			unicConn  = fakeUnicastConn{
+				localAddr: localAddr,
+				readChan:  readCh,
+				writeChan: writeCh,
+				written:   make(chan fakeMsgRecord, 16),
+			}
+			manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig())
+		)
+		manager.registerPeer(MakePeer(peerAddr.IP))
+		manager.Start()
+		defer manager.Stop()
+
+		go func() {
+			<-writeCh
+			// The peer's clock is an hour ahead
+			peerNow := formatTimestamp(time.Now().Add(offset))
+			readCh <- fakeMsgRecord{
+				IsUnicast: true,
+				From:      peerAddr,
+				To:        localAddr,
+				Payload:   []byte(heartbeatReplyMessage + " 1 " + peerNow + " " + peerNow),
+			}
+		}()
+
+		_, err := manager.Ping(context.Background(), MakePeer(peerAddr.IP))
+		assert.Nil(t, err)
+
+		peer, _ := manager.Peer(peerAddr.IP)
+		assert.InDelta(t, offset, peer.ClockOffset, float64(peer.ClockUncertainty+time.Millisecond))
+		assert.Less(t, peer.ClockUncertainty, time.Second)
+	})
+
+	t.Run("The timestamps are taken when the probes are written", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			peerAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+			readCh    = make(chan fakeMsgRecord)
+			writeCh   = make(chan fakeMsgRecord)
+			unicConn  = fakeUnicastConn{
+				localAddr: localAddr,
+				readChan:  readCh,
+				writeChan: writeCh,
+				written:   make(chan fakeMsgRecord, 16),
+			}
+			// The first message is held for 100ms, and the rest wait behind it
+			wait = 100 * time.Millisecond
+		)
+
+		manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig())
+		manager.registerPeer(MakePeer(peerAddr.IP))
+		manager.Start()
+		defer manager.Stop()
+
+		manager.answerHeartbeat(peerAddr.IP, []byte(heartbeatMessage+" 7"))
+		manager.answerHeartbeat(peerAddr.IP, []byte(heartbeatMessage+" 8"))
+		time.Sleep(wait)
+		<-writeCh
+
+		reply := <-writeCh
+		args := probeArgs(reply.Payload)
+		assert.Len(t, args, 3)
+
+		received, _ := parseTimestamp(args[1])
+		replied, _ := parseTimestamp(args[2])
+		assert.GreaterOrEqual(t, replied.Sub(received), wait/2)
+
+		// The heartbeat waits behind a reply, which mustn't count in the round
+		// trip
+		manager.answerHeartbeat(peerAddr.IP, []byte(heartbeatMessage+" 9"))
+		go func() {
+			time.Sleep(wait)
+			<-writeCh
+
+			msg := <-writeCh
+			seq, _ := probeSeq(msg.Payload)
+			readCh <- fakeMsgRecord{
+				IsUnicast: true,
+				From:      peerAddr,
+				To:        localAddr,
+				Payload:   []byte(heartbeatReplyMessage + " " + strconv.FormatUint(seq, 10)),
+			}
+		}()
+
+		rtt, err := manager.Ping(context.Background(), MakePeer(peerAddr.IP))
+		assert.Nil(t, err)
+		assert.Less(t, rtt, wait/2)
+	})
+}
@@ -1,13 +1,31 @@
 package prototari
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// messagesChCapacity is the number of received messages the CommsManager
+	// buffers before it starts discarding new ones.
+	messagesChCapacity = 64
+
+	// senderIdleTimeout is the time a send queue is kept without messages
+	// before its sender goroutine finishes.
+	senderIdleTimeout = time.Minute
+)
+
+// ErrUnknownPeer is returned when sending a message to a peer that isn't
+// registered.
+var ErrUnknownPeer = errors.New("unknown peer")
+
 // A CommsManager is the central authority in the Pelotari protocol.
 // It deals with discovering and registering peers, as well as sending periodic
 // heartbeats to those peers from whom it hasn't heard anything in a specified
@@ -16,51 +34,117 @@ type CommsManager struct {
 	broadcaster BroadcastConn
 	unicaster   UnicastConn
 
-	config Config
+	config        Config
+	configMutex   sync.RWMutex
+	configChanged chan struct{}
+	discoverCh    chan struct{}
+	logHandler    *swapHandler
+	logger        *slog.Logger
+
+	peersCh           chan []Peer
+	peers             map[string]Peer
+	candidates        map[string]Peer
+	relays            map[string][]probeRelay
+	rejections        map[string]rejection
+	thresholdDetector *ThresholdDetector
+
+	probeSeq     atomic.Uint64
+	pending      map[uint64]pendingProbe
+	pendingMutex sync.Mutex
+	peersMutex   sync.RWMutex
+	gossip       gossip
 
-	peersCh    chan []Peer
-	peers      map[string]Peer
-	peersMutex sync.RWMutex
+	messagesCh chan Message
 
-	isRunning bool
-	done      chan struct{}
-	wg        sync.WaitGroup
+	queues      map[string]*sendQueue
+	queuesMutex sync.Mutex
+	sendersWg   sync.WaitGroup
+
+	outboundLimits  atomic.Pointer[outboundLimits]
+	inboundLimiter  *inboundLimiter
+	outboundDelayed atomic.Uint64
+
+	metrics  metrics
+	observer Observer
+
+	state      lifecycleState
+	stateMutex sync.Mutex
+	done       chan struct{}
+	fatalErrCh chan error
+	errorsCh   chan error
+	wg         sync.WaitGroup
 }
 
 // MakeUDPManager returns an instance of a CommsManager with the broadcaster
 // and unicaster connected and ready to send UDP messages.
-func MakeUDPManager(config Config) *CommsManager {
+// The parameters left at zero, other than MaxPeers and BroadcastInterval, are
+// set to their defaults when zero isn't valid for them.
+// It returns an error if the configuration isn't valid or the connections
+// can't be opened.
+func MakeUDPManager(config Config) (*CommsManager, error) {
+	config = config.withDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	var (
-		broadcaster = UDPBroadcastConn{}
-		unicaster   = UDPUnicastConn{}
+		broadcaster = UDPBroadcastConn{Port: config.BroadcastPort}
+		unicaster   = UDPUnicastConn{Port: config.UnicastPort}
 	)
 
-	broadcaster.Connect()
-	unicaster.Connect()
+	if err := broadcaster.Connect(); err != nil {
+		return nil, err
+	}
+	if err := unicaster.Connect(); err != nil {
+		broadcaster.Close()
+		return nil, err
+	}
 
 	return MakeManager(
 		&broadcaster,
 		&unicaster,
 		config,
-	)
+	), nil
 }
 
 // MakeManager returns an instance of a CommsManager with the passed in
 // broadcaster and unicaster. The underlying connections of the messagers
 // have to be connected by the client using this factory.
+// The parameters left at zero, other than MaxPeers and BroadcastInterval, are
+// set to their defaults when zero isn't valid for them.
 func MakeManager(
 	broadcaster BroadcastConn,
 	unicaster UnicastConn,
 	config Config,
 ) *CommsManager {
-	return &CommsManager{
-		broadcaster: broadcaster,
-		unicaster:   unicaster,
-		config:      config,
-		peersCh:     make(chan []Peer, 1),
-		peers:       make(map[string]Peer, config.MaxPeers),
-		isRunning:   false,
+	now := time.Now()
+	config = config.withDefaults()
+
+	m := &CommsManager{
+		broadcaster:       broadcaster,
+		unicaster:         unicaster,
+		config:            config,
+		configChanged:     make(chan struct{}, 1),
+		discoverCh:        make(chan struct{}, 1),
+		logHandler:        makeSwapHandler(config.logger().Handler()),
+		peersCh:           make(chan []Peer, 1),
+		peers:             make(map[string]Peer, config.MaxPeers),
+		candidates:        make(map[string]Peer),
+		relays:            make(map[string][]probeRelay),
+		rejections:        make(map[string]rejection),
+		pending:           make(map[uint64]pendingProbe),
+		thresholdDetector: MakeThresholdDetector(config.MaxMissedHeartbeats),
+		messagesCh:        make(chan Message, messagesChCapacity),
+		inboundLimiter:    makeInboundLimiter(config.RateLimits.InboundPackets),
+		state:             lifecycleNew,
+		fatalErrCh:        make(chan error, 1),
+		errorsCh:          make(chan error, errorsChCapacity),
 	}
+	m.logger = slog.New(m.logHandler)
+	m.observer = MultiObserver(&m.metrics, config.Observer)
+	m.outboundLimits.Store(makeOutboundLimits(config.RateLimits, now))
+
+	return m
 }
 
 // PeersCh returns a channel of the registered peers.
@@ -78,64 +162,154 @@ func (m *CommsManager) NOfPeers() int {
 	return len(m.peers)
 }
 
+// peerAddress returns the address of the unicast connection of the computer
+// with the given IP.
+func (m *CommsManager) peerAddress(IP net.IP) *net.UDPAddr {
+	return &net.UDPAddr{
+		IP:   IP,
+		Port: m.Config().UnicastPort,
+	}
+}
+
 // hasPeer checks if a peer with a given IP is registered.
 func (m *CommsManager) hasPeer(IP net.IP) bool {
 	m.peersMutex.RLock()
 	defer m.peersMutex.RUnlock()
 
-	_, ok := m.peers[string(IP)]
+	_, ok := m.peers[peerKey(IP)]
 	return ok
 }
 
+// Run starts the communications and blocks until the context is cancelled,
+// at which point the communications are stopped.
+// The connections are kept open; call Close() to close them.
+//
+// If a fatal error prevents the communications from working (for example, a
+// connection is closed from under the CommsManager), the communications are
+// stopped and the error returned.
+func (m *CommsManager) Run(ctx context.Context) error {
+	if err := m.Start(); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return m.Stop()
+	case err := <-m.fatalErrCh:
+		m.Stop()
+		return err
+	}
+}
+
 // Start begins the peer discovery and heartbeat mechanisms and listens for
 // incoming messages from registered peers.
-func (m *CommsManager) Start() {
-	if m.isRunning {
-		return
+// A stopped CommsManager can be started again, but starting one that's already
+// running returns ErrRunning, and one that's closed, ErrClosed.
+func (m *CommsManager) Start() error {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	if err := m.state.canStart(); err != nil {
+		return err
+	}
+
+	// Discard fatal errors from a previous run
+	select {
+	case <-m.fatalErrCh:
+	default:
 	}
 
-	m.isRunning = true
+	// Reads may have been interrupted by a previous stop
+	m.broadcaster.SetReadDeadline(time.Time{})
+	m.unicaster.SetReadDeadline(time.Time{})
+
+	m.state = lifecycleRunning
 	m.done = make(chan struct{})
+	m.openSendQueues()
 	m.wg = sync.WaitGroup{}
-	m.wg.Add(3)
+	m.wg.Add(4)
 
 	go m.startBroadcasting()
 	go m.startRespondingToBroadcasts()
 	go m.startListeningToUnicast()
+	go m.startHeartbeating()
+
+	return nil
 }
 
 func (m *CommsManager) startBroadcasting() {
 	defer func() {
 		m.wg.Done()
-		log.Println("[Close] Broadcasting goroutine done!")
+		m.logger.Debug("Broadcasting goroutine done")
 	}()
 
+	var (
+		lastBroadcast = time.Now()
+		schedule      = makeDiscoverySchedule()
+		interval      = schedule.interval(m.Config())
+	)
+
 	for {
-		select {
-		case <-m.done:
-			return
-		default:
-			if m.NOfPeers() < m.config.MaxPeers {
-				_, err := m.broadcaster.Write([]byte(discoveryMessage))
-				if err != nil {
-					log.Println("Sending a broadcast message failed")
+		var (
+			config = m.Config()
+			wait   = time.Until(lastBroadcast.Add(interval))
+		)
+
+		if wait <= 0 {
+			if m.seekingPeers(config) {
+				payload := m.discoveryPayload(config)
+				if _, err := m.broadcaster.Write(payload); err != nil {
+					m.reportErr(&SendError{Err: err})
+					m.logger.Warn("Sending a broadcast message failed", slog.Any("error", err))
+				} else {
+					m.observer.OnPacketSent(nil, payload)
 				}
 			}
 
-			select {
-			case <-time.After(m.config.BroadcastInterval):
-				continue
-			case <-m.done:
-				return
-			}
+			lastBroadcast = time.Now()
+			schedule.broadcastSent()
+			interval = schedule.interval(config)
+			wait = interval
 		}
+
+		// A reconfigured interval applies to the ongoing wait
+		select {
+		case <-time.After(wait):
+		case <-m.configChanged:
+			interval = schedule.interval(m.Config())
+		case <-m.discoverCh:
+			lastBroadcast = time.Now()
+			schedule.reset()
+			interval = schedule.interval(m.Config())
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Discover broadcasts the discovery message after a short random delay (up to
+// the DiscoveryJitter of the first interval), instead of waiting for the next
+// broadcast, and starts over with the fast broadcasts. Call it when
+// the network changes. As with the periodic broadcasts, nothing is broadcast if
+// the maximum number of peers is registered and the FullPolicy refuses new
+// ones.
+// It returns ErrNotRunning if the communications aren't running.
+func (m *CommsManager) Discover() error {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	if m.state != lifecycleRunning {
+		return ErrNotRunning
 	}
+
+	signal(m.discoverCh)
+	return nil
 }
 
 func (m *CommsManager) startRespondingToBroadcasts() {
 	defer func() {
 		m.wg.Done()
-		log.Println("[Close] Broadcaster responder goroutine done!")
+		m.logger.Debug("Broadcast responder goroutine done")
 	}()
 
 	var (
@@ -144,100 +318,422 @@ func (m *CommsManager) startRespondingToBroadcasts() {
 	)
 
 	for {
-		select {
-		case <-m.done:
-			return
-		default:
-			n, addr, err := m.broadcaster.Read(buff)
-			if err != nil {
-				continue
+		n, addr, err := m.broadcaster.Read(buff)
+		if err != nil {
+			if m.handleReadError("broadcast", err) {
+				return
 			}
+			continue
+		}
 
-			// Ignore our own broadcast messages
-			if myIP.Equal(addr.IP) {
-				continue
-			}
+		// Ignore our own broadcast messages
+		if myIP.Equal(addr.IP) {
+			continue
+		}
 
-			if string(buff[:n]) == discoveryMessage && !m.hasPeer(addr.IP) {
-				peerAddr := *addr
-				peerAddr.Port = UnicastPort
+		if !m.inboundLimiter.allow(addr.IP) {
+			continue
+		}
+		m.observer.OnPacketReceived(addr, buff[:n])
 
-				_, err := m.unicaster.Write([]byte(responseMessage), &peerAddr)
-				if err != nil {
-					log.Printf("Couldn't send response to %s: %s\n", peerAddr.IP, err)
-				}
-			}
+		config := m.Config()
+		if kindOf(buff[:n]) != kindDiscovery ||
+			!m.shouldRespond(addr.IP) ||
+			m.responding(addr.IP) ||
+			!answersBroadcast(buff[:n], config.DiscoveryNearlyFull) {
+			continue
+		}
+
+		// Without tracking it, nothing would stop answering its next
+		// broadcasts before this response is sent
+		if !m.advanceCandidate(addr.IP, PeerDiscovered) {
+			continue
+		}
+
+		// The responses are spread over the delay, so that the broadcaster
+		// doesn't get them all at once
+		if config.DiscoveryResponseDelay > 0 {
+			m.wg.Add(1)
+			go m.respondAfter(addr.IP, rand.N(config.DiscoveryResponseDelay))
+		} else {
+			m.respond(addr.IP)
 		}
 	}
 }
 
+// respond sends the response to the discovery message of a computer.
+func (m *CommsManager) respond(IP net.IP) {
+	peerAddr := m.peerAddress(IP)
+
+	err := m.send(context.Background(), []byte(responseMessage), peerAddr, PriorityControl)
+	if err != nil {
+		m.reportErr(&SendError{Addr: peerAddr, Err: err})
+		m.logger.Warn(
+			"Couldn't send response",
+			slog.String("peer", peerAddr.IP.String()),
+			slog.Any("error", err),
+		)
+		// The next discovery message is answered again
+		m.forgetCandidate(IP)
+		return
+	}
+
+	m.logger.Debug("Responded to broadcast", slog.String("peer", peerAddr.IP.String()))
+	m.advanceCandidate(IP, PeerHandshakePending)
+	m.observer.OnHandshake(HandshakeEvent{
+		IP:    peerAddr.IP,
+		Role:  HandshakeResponder,
+		Stage: HandshakeStarted,
+	})
+}
+
+// shouldRespond returns whether to answer the discovery message of a computer:
+// one that isn't registered yet and is admitted, if there's room for it and it
+// wasn't left alone for a while, for rejecting this computer for being full or
+// being replaced.
+func (m *CommsManager) shouldRespond(IP net.IP) bool {
+	config := m.Config()
+
+	return !m.hasPeer(IP) &&
+		config.admits(IP) &&
+		m.hasRoomFor(IP, config) &&
+		!m.backingOff(IP)
+}
+
 func (m *CommsManager) startListeningToUnicast() {
 	defer func() {
 		m.wg.Done()
-		log.Println("[Close] Unicaster goroutine done!")
+		m.logger.Debug("Unicast listener goroutine done")
 	}()
 
 	buff := make([]byte, 1024)
 
 	for {
-		select {
-		case <-m.done:
-			return
-		default:
-			n, addr, err := m.unicaster.Read(buff)
-			if err != nil {
-				continue
+		n, addr, err := m.unicaster.Read(buff)
+		if err != nil {
+			if m.handleReadError("unicast", err) {
+				return
 			}
+			continue
+		}
 
-			message := buff[:n]
-			if string(message) == responseMessage {
-				// TODO: handle error
-				m.completeHandshake(addr)
-			} else if string(message) == confirmationMessage {
-				peer := MakePeer(addr.IP)
-				// TODO: handle error
-				m.registerPeer(peer)
+		message := buff[:n]
+		if !m.inboundLimiter.allow(addr.IP) {
+			continue
+		}
+		m.observer.OnPacketReceived(addr, message)
+		_, registered := m.peerSeen(addr.IP)
+
+		switch kind := kindOf(message); kind {
+		case kindHeartbeat, kindHeartbeatReply, kindIndirectProbe, kindIndirectProbeReply:
+			if registered {
+				m.handleProbe(addr.IP, kind, message)
+			}
+		case kindResponse:
+			if err := m.completeHandshake(addr, message); err != nil {
+				m.reportErr(err)
+			}
+		case kindConfirmation:
+			if err := m.acceptHandshake(addr, message); err != nil {
+				m.reportErr(err)
 			}
+		case kindReject:
+			if err := m.handleRejection(addr, message); err != nil {
+				m.reportErr(err)
+			}
+		case kindDisconnect:
+			m.unregisterPeer(addr.IP, EvictionDisconnected)
+		default:
+			m.deliverMessage(addr, message)
 		}
 	}
 }
 
+// handleReadError decides what to do with an error returned by a connection's
+// Read and returns whether the reading goroutine should finish.
+//
+// The reads block until a message arrives, so when stopping, the read deadline
+// is set to the past to unblock them. Timeouts are thus expected, and the
+// goroutine finishes if the CommsManager is stopping. Fatal errors, like the
+// connection being closed, are reported to the application and also finish
+// the goroutine. Any other error is logged, and the goroutine keeps reading.
+func (m *CommsManager) handleReadError(connName string, err error) bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+	}
+
+	if isTimeoutErr(err) {
+		return false
+	}
+
+	readErr := &ReadError{
+		Conn:  connName,
+		Fatal: isFatalReadErr(err),
+		Err:   err,
+	}
+	m.reportErr(readErr)
+
+	if readErr.Fatal {
+		m.reportFatalErr(readErr)
+		return true
+	}
+
+	m.logger.Warn(
+		"Reading failed",
+		slog.String("conn", connName),
+		slog.Any("error", err),
+	)
+	return false
+}
+
+// reportFatalErr hands an error that prevents the communications from working
+// to Run, which stops the communications. Only the first fatal error is kept
+// until read.
+func (m *CommsManager) reportFatalErr(err error) {
+	m.logger.Error("Communications failed", slog.Any("error", err))
+
+	select {
+	case m.fatalErrCh <- err:
+	default:
+	}
+}
+
 // completeHandshake is called by the broadcaster to add the responder as a peer
-// and send the confirmation message that completes the handshake.
+// and send the confirmation message that completes the handshake, or the
+// reject message if it can't be registered.
 //
-// It returns an error if the maximum number of peers are already registered.
-func (m *CommsManager) completeHandshake(peerAddr *net.UDPAddr) error {
-	peer := MakePeer(peerAddr.IP)
-	if err := m.registerPeer(peer); err != nil {
-		return err
+// It returns a *HandshakeError wrapping ErrMaxPeers if the maximum number of
+// peers are already registered, ErrNotAdmitted if the admission filter rejects
+// the responder, ErrVersionMismatch if it speaks another version of the
+// protocol, or the error queueing the confirmation. The responses of computers
+// that rejected this one for being full recently are ignored.
+//
+// When two computers discover each other at once, both respond to the other's
+// broadcast. Only the one with the lower IP goes on as the broadcaster, so the
+// other ignores the response and waits for the confirmation. The responses of
+// registered peers, which missed the confirmation, are confirmed again.
+func (m *CommsManager) completeHandshake(peerAddr *net.UDPAddr, response []byte) error {
+	if m.backingOff(peerAddr.IP) {
+		return nil
+	}
+
+	if m.respondedTo(peerAddr.IP) && !m.initiates(peerAddr.IP) {
+		m.logger.Debug(
+			"Ignoring response: waiting for the confirmation",
+			slog.String("peer", peerAddr.IP.String()),
+		)
+		return nil
+	}
+
+	if m.hasPeer(peerAddr.IP) {
+		m.sendControl(confirmationMessage, peerAddr.IP)
+		return nil
+	}
+
+	var (
+		peer  = MakePeer(peerAddr.IP)
+		event = HandshakeEvent{
+			IP:    peerAddr.IP,
+			Role:  HandshakeBroadcaster,
+			Stage: HandshakeStarted,
+		}
+	)
+	m.observer.OnHandshake(event)
+
+	err := checkVersion(response)
+	if err == nil {
+		err = m.registerPeer(peer)
+	}
+	if err != nil {
+		m.reject(peerAddr, err)
+		event.Stage, event.Err = HandshakeRejected, err
+		m.observer.OnHandshake(event)
+		return &HandshakeError{Peer: peer, Role: HandshakeBroadcaster, Err: err}
 	}
 
-	_, err := m.unicaster.Write([]byte(confirmationMessage), peer.Address())
+	event.Stage = HandshakeCompleted
+	m.observer.OnHandshake(event)
 
-	return err
+	err = m.send(context.Background(), []byte(confirmationMessage), m.peerAddress(peer.IP), PriorityControl)
+	if err != nil {
+		return &HandshakeError{Peer: peer, Role: HandshakeBroadcaster, Err: err}
+	}
+
+	return nil
+}
+
+// acceptHandshake is called by the responder when the confirmation message
+// arrives, to add the broadcaster as peer, or send it the reject message if it
+// can't be registered, so that it unregisters this computer too.
+//
+// It returns a *HandshakeError wrapping ErrMaxPeers if the maximum number of
+// peers are already registered, ErrNotAdmitted if the admission filter rejects
+// the broadcaster, or ErrVersionMismatch if it speaks another version of the
+// protocol.
+func (m *CommsManager) acceptHandshake(peerAddr *net.UDPAddr, confirmation []byte) error {
+	var (
+		peer  = MakePeer(peerAddr.IP)
+		event = HandshakeEvent{IP: peerAddr.IP, Role: HandshakeResponder}
+	)
+
+	// A repeated confirmation
+	if m.hasPeer(peerAddr.IP) {
+		return nil
+	}
+
+	err := checkVersion(confirmation)
+	if err == nil {
+		err = m.registerPeer(peer)
+	}
+	if err != nil {
+		m.reject(peerAddr, err)
+		m.forgetCandidate(peerAddr.IP)
+		event.Stage, event.Err = HandshakeRejected, err
+		m.observer.OnHandshake(event)
+		return &HandshakeError{Peer: peer, Role: HandshakeResponder, Err: err}
+	}
+
+	event.Stage = HandshakeCompleted
+	m.observer.OnHandshake(event)
+
+	return nil
 }
 
 // registerPeer attempts to register a peer and sends a message to the peers
-// channel with the new registered peers.
+// channel with the new registered peers. If the maximum number of peers are
+// already registered, the peer may replace one of them, which is evicted (see
+// makeRoom). Registering a peer that's already registered does nothing.
 //
-// It returns an error if the maximum number of peers are already registered.
+// It returns ErrNotAdmitted if the admission filter rejects the peer, and
+// ErrMaxPeers if there's no room for it.
 func (m *CommsManager) registerPeer(peer Peer) error {
+	config := m.Config()
+	if !config.admits(peer.IP) {
+		return ErrNotAdmitted
+	}
+
+	var (
+		key     = peerKey(peer.IP)
+		changes []PeerStateChange
+	)
+
 	m.peersMutex.Lock()
-	defer m.peersMutex.Unlock()
 
-	if len(m.peers) >= m.config.MaxPeers {
-		return errors.New("max peers registered")
+	if _, ok := m.peers[key]; ok {
+		m.peersMutex.Unlock()
+		return nil
 	}
 
-	m.peers[string(peer.IP)] = peer
+	replaced, err := m.makeRoom(peer.IP, config)
+	if err != nil {
+		m.peersMutex.Unlock()
+		return err
+	}
+	if replaced != nil {
+		delete(m.peers, peerKey(replaced.IP))
+	}
 
-	// Send the new peers to the peers channel, replacing the last sent
-	// value, if any.
-	peers := make([]Peer, 0, len(m.peers))
-	for _, peer := range m.peers {
-		peers = append(peers, peer)
+	// The peer comes from the discovery, if it went through it
+	if candidate, ok := m.candidates[key]; ok {
+		peer.State = candidate.State
+		delete(m.candidates, key)
 	}
 
+	peer.Registered = time.Now()
+	delete(m.rejections, key)
+	m.failureDetector(config).Heard(peer.IP, peer.LastSeen)
+	changes = append(changes, changeState(&peer, PeerConnected))
+	m.peers[key] = peer
+	m.publishPeers()
+	m.peersMutex.Unlock()
+
+	// The observer is notified without holding the lock, so that it can
+	// query the CommsManager
+	m.logger.Info("Peer registered", slog.String("peer", peer.IP.String()))
+	m.notifyStateChanges(changes...)
+	m.observer.OnPeerRegistered(peer)
+
+	// The replaced peer is left alone for a while, so that it doesn't
+	// replace another right away
+	if replaced != nil {
+		m.backOff(replaced.IP)
+		m.dismiss(*replaced, EvictionReplaced)
+	}
+
+	return nil
+}
+
+// unregisterPeer removes the peer with the given IP, if registered, and sends
+// a message to the peers channel with the remaining peers.
+// It returns whether the peer was registered.
+func (m *CommsManager) unregisterPeer(IP net.IP, reason EvictionReason) bool {
+	m.peersMutex.Lock()
+
+	peer, ok := m.peers[peerKey(IP)]
+	if !ok {
+		m.peersMutex.Unlock()
+		return false
+	}
+
+	delete(m.peers, peerKey(IP))
+	m.publishPeers()
+	m.peersMutex.Unlock()
+
+	m.notifyUnregistered(peer, reason)
+
+	return true
+}
+
+// Disconnect evicts the peer with the given IP, sending it the disconnect
+// message so that it unregisters this computer too.
+// It returns ErrUnknownPeer if the peer isn't registered.
+//
+// The peer may be discovered and registered again later; use the admission
+// filter to keep it away.
+func (m *CommsManager) Disconnect(IP net.IP) error {
+	evicted := m.evictPeers(EvictionRequested, func(peers []Peer) []Peer {
+		for _, peer := range peers {
+			if peer.IP.Equal(IP) {
+				return []Peer{peer}
+			}
+		}
+		return nil
+	})
+
+	if len(evicted) == 0 {
+		return ErrUnknownPeer
+	}
+
+	return nil
+}
+
+// notifyUnregistered logs the removal of a peer and notifies the observer of
+// the change to the disconnected state and the eviction.
+// It must be called without holding the peers mutex.
+func (m *CommsManager) notifyUnregistered(peer Peer, reason EvictionReason) {
+	m.logger.Info(
+		"Peer unregistered",
+		slog.String("peer", peer.IP.String()),
+		slog.String("reason", reason.String()),
+	)
+	m.failureDetector(m.Config()).Forget(peer.IP)
+	m.notifyStateChanges(changeState(&peer, PeerDisconnected))
+	m.observer.OnPeerEvicted(peer, reason)
+
+	// Losing every peer may be due to a network change
+	if reason != EvictionStopped && m.NOfPeers() == 0 {
+		signal(m.discoverCh)
+	}
+}
+
+// publishPeers sends the registered peers to the peers channel, replacing the
+// last sent value, if any.
+// The caller must hold the peers mutex.
+func (m *CommsManager) publishPeers() {
+	peers := m.sortedPeers()
+
 	select {
 	case <-m.peersCh:
 		// There was an unread item, just discarded
@@ -245,25 +741,457 @@ func (m *CommsManager) registerPeer(peer Peer) error {
 	default:
 		m.peersCh <- peers
 	}
-
-	return nil
 }
 
-// Stop signals all the CommsManager goroutines to stop.
-func (m *CommsManager) Stop() {
-	if !m.isRunning {
+// deliverMessage hands a message from a registered peer to the application.
+// Messages from unknown senders are ignored, and if the application isn't
+// keeping up with the received messages, the message is discarded.
+func (m *CommsManager) deliverMessage(from *net.UDPAddr, payload []byte) {
+	m.peersMutex.RLock()
+	peer, ok := m.peers[peerKey(from.IP)]
+	m.peersMutex.RUnlock()
+
+	if !ok {
 		return
 	}
 
+	// The read buffer is reused by the listening goroutine
+	message := Message{
+		From:    peer,
+		Payload: append([]byte(nil), payload...),
+	}
+
+	select {
+	case m.messagesCh <- message:
+	default:
+		m.logger.Warn(
+			"Discarding message: receive buffer full",
+			slog.String("peer", peer.IP.String()),
+		)
+	}
+}
+
+// Receive blocks until a message from a registered peer arrives or the context
+// is done, in which case the context's error is returned.
+func (m *CommsManager) Receive(ctx context.Context) (Message, error) {
+	select {
+	case message := <-m.messagesCh:
+		return message, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// SendMessage queues a message to be sent to all the registered peers.
+// It stops queueing as soon as the context is done, returning its error.
+// Otherwise, the errors from the peers the message couldn't be queued for are
+// returned joined. See SendMessageTo for the possible errors.
+func (m *CommsManager) SendMessage(ctx context.Context, payload []byte) error {
+	var errs []error
+
+	for _, peer := range m.Peers() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := m.send(ctx, payload, m.peerAddress(peer.IP), PriorityData)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sending message to %s: %w", peer.IP, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SendMessageTo queues a message to be sent to a registered peer.
+// The message is sent asynchronously by the peer's sender goroutine.
+//
+// It returns ErrUnknownPeer if the peer isn't registered, and ErrQueueClosed
+// if the communications aren't running. When the peer's send queue is full,
+// the configured overflow policy applies: blocking returns the context's error
+// if it's done before there's room in the queue, and the error policy returns
+// ErrQueueFull.
+func (m *CommsManager) SendMessageTo(ctx context.Context, peer Peer, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !m.hasPeer(peer.IP) {
+		return ErrUnknownPeer
+	}
+
+	return m.send(ctx, payload, m.peerAddress(peer.IP), PriorityData)
+}
+
+// send queues a message for the sender goroutine of its destination.
+// The payload is copied, so the caller is free to reuse it.
+//
+// Control messages are queued by the protocol goroutines, which mustn't block
+// on a slow destination, and so they always drop the oldest queued control
+// message when the queue is full. Data messages follow the configured policy.
+func (m *CommsManager) send(
+	ctx context.Context,
+	payload []byte,
+	to *net.UDPAddr,
+	priority Priority,
+) error {
+	return m.sendStamped(ctx, payload, to, priority, nil)
+}
+
+// sendStamped queues a message like send, with the function stamping it right
+// before it's written, if any.
+func (m *CommsManager) sendStamped(
+	ctx context.Context,
+	payload []byte,
+	to *net.UDPAddr,
+	priority Priority,
+	stamp func(payload []byte, now time.Time) []byte,
+) error {
+	var (
+		message = outgoingMessage{
+			payload:  append([]byte(nil), payload...),
+			to:       to,
+			priority: priority,
+			stamp:    stamp,
+		}
+		policy = m.Config().SendQueueOverflow
+	)
+
+	if priority == PriorityControl {
+		policy = OverflowDropOldest
+	}
+
+	for {
+		queue, err := m.sendQueue(to)
+		if err != nil {
+			return err
+		}
+
+		// The queue may have been retired for being idle after getting it;
+		// a new one is created in that case.
+		err = queue.push(ctx, message, policy)
+		if !errors.Is(err, errQueueRetired) {
+			return err
+		}
+	}
+}
+
+// sendQueue returns the send queue for a destination, creating it and starting
+// its sender goroutine if it doesn't exist.
+func (m *CommsManager) sendQueue(to *net.UDPAddr) (*sendQueue, error) {
+	m.queuesMutex.Lock()
+	defer m.queuesMutex.Unlock()
+
+	if m.queues == nil {
+		return nil, ErrQueueClosed
+	}
+
+	key := to.String()
+	queue, ok := m.queues[key]
+	if !ok {
+		queue = makeSendQueue(m.Config().SendQueueCapacity)
+		m.queues[key] = queue
+
+		m.sendersWg.Add(1)
+		go m.startSending(key, queue)
+	}
+
+	return queue, nil
+}
+
+// startSending writes the messages from a send queue into the unicast
+// connection, respecting the outgoing rate limits. It finishes when the queue
+// is closed, or after it's been idle for some time, so that destinations that
+// are no longer written to don't keep a goroutine around.
+func (m *CommsManager) startSending(key string, queue *sendQueue) {
+	defer m.sendersWg.Done()
+
+	limiter := makeOutboundLimiter(m.outboundLimits.Load(), time.Now())
+
+	for {
+		message, ok := queue.pop(senderIdleTimeout)
+		if !ok {
+			if m.retireSendQueue(key, queue) {
+				return
+			}
+			continue
+		}
+
+		// The limits may have been reconfigured since the last message
+		if limits := m.outboundLimits.Load(); limits != limiter.limits {
+			limiter = makeOutboundLimiter(limits, time.Now())
+		}
+
+		// The control messages aren't limited, so that the heartbeats and
+		// the handshakes get through however much data is sent
+		var delay time.Duration
+		if message.priority != PriorityControl {
+			delay = limiter.delay(len(message.payload), time.Now())
+		}
+
+		if delay > 0 {
+			m.outboundDelayed.Add(1)
+			if !m.waitToSend(queue, delay) {
+				continue
+			}
+		}
+
+		m.write(message)
+	}
+}
+
+// waitToSend waits for a delayed data message to be within the rate limits,
+// writing the control messages queued meanwhile, which aren't limited.
+// Closing the queue cuts the wait short: it returns true when draining it, so
+// that the message is sent right away, and false when discarding it, so that
+// it's dropped.
+func (m *CommsManager) waitToSend(queue *sendQueue, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		for {
+			message, ok := queue.popControl()
+			if !ok {
+				break
+			}
+			m.write(message)
+		}
+
+		select {
+		case <-timer.C:
+			return true
+		case <-queue.ready:
+		case <-queue.done:
+			return !queue.isDiscarded()
+		}
+	}
+}
+
+// write stamps a message, if it carries the time it's sent, and writes it to
+// the unicast connection.
+func (m *CommsManager) write(message outgoingMessage) {
+	if message.stamp != nil {
+		message.payload = message.stamp(message.payload, time.Now())
+	}
+
+	if _, err := m.unicaster.Write(message.payload, message.to); err != nil {
+		m.reportErr(&SendError{Addr: message.to, Err: err})
+		m.logger.Warn(
+			"Couldn't send message",
+			slog.String("peer", message.to.IP.String()),
+			slog.String("type", kindOf(message.payload).String()),
+			slog.Any("error", err),
+		)
+	} else {
+		m.observer.OnPacketSent(message.to, message.payload)
+	}
+}
+
+// retireSendQueue removes an empty send queue, returning whether it was
+// removed. A message could have been pushed after the sender stopped waiting,
+// in which case the queue is kept.
+func (m *CommsManager) retireSendQueue(key string, queue *sendQueue) bool {
+	m.queuesMutex.Lock()
+	defer m.queuesMutex.Unlock()
+
+	if !queue.retireIfEmpty() {
+		return false
+	}
+
+	if m.queues[key] == queue {
+		delete(m.queues, key)
+	}
+
+	return true
+}
+
+// RateLimitStats returns the counters of the packets affected by the rate
+// limits.
+func (m *CommsManager) RateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		OutboundDelayed: m.outboundDelayed.Load(),
+		InboundDropped:  m.inboundLimiter.dropped.Load(),
+	}
+}
+
+// openSendQueues allows messages to be queued.
+func (m *CommsManager) openSendQueues() {
+	m.queuesMutex.Lock()
+	defer m.queuesMutex.Unlock()
+
+	m.queues = make(map[string]*sendQueue)
+}
+
+// closeSendQueues stops the send queues from accepting messages, discarding
+// the queued ones if discard is true. The closed queues are returned.
+func (m *CommsManager) closeSendQueues(discard bool) []*sendQueue {
+	m.queuesMutex.Lock()
+	defer m.queuesMutex.Unlock()
+
+	queues := make([]*sendQueue, 0, len(m.queues))
+	for _, queue := range m.queues {
+		queue.close(discard)
+		queues = append(queues, queue)
+	}
+	m.queues = nil
+
+	return queues
+}
+
+// Stop signals all the CommsManager goroutines to stop, waits for them to
+// finish and deregisters all the peers.
+// It returns ErrNotRunning if the CommsManager isn't running, and ErrClosed if
+// it's closed.
+func (m *CommsManager) Stop() error {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	if err := m.state.canStop(); err != nil {
+		return err
+	}
+
+	m.stop()
+
+	return nil
+}
+
+// stop moves a running CommsManager to the stopped state.
+// The caller must hold the state mutex.
+func (m *CommsManager) stop() {
+	m.stopProtocol()
+	m.stopSending()
+}
+
+// stopProtocol signals the protocol goroutines to stop and waits for them to
+// finish, so that they don't queue messages anymore. The sender goroutines keep
+// running.
+func (m *CommsManager) stopProtocol() {
 	close(m.done)
+
+	// Unblock the pending reads
+	now := time.Now()
+	m.broadcaster.SetReadDeadline(now)
+	m.unicaster.SetReadDeadline(now)
+
 	m.wg.Wait()
-	m.isRunning = false
+}
+
+// stopSending finishes stopping the communications after stopProtocol: it
+// discards the queued messages, waits for the sender goroutines to finish and
+// deregisters the peers.
+func (m *CommsManager) stopSending() {
+	m.closeSendQueues(true)
+	m.sendersWg.Wait()
+	m.clearPeers()
+	m.state = lifecycleStopped
+}
+
+// clearPeers deregisters all the peers, forgets the computers in the middle of
+// the discovery and sends the empty list to the peers channel.
+func (m *CommsManager) clearPeers() {
+	m.peersMutex.Lock()
+
+	// Every candidate was seen before now
+	forgotten := m.forgetCandidates(time.Now().Add(time.Nanosecond))
+
+	if len(m.peers) == 0 {
+		m.peersMutex.Unlock()
+		m.notifyStateChanges(forgotten...)
+		return
+	}
+
+	peers := make([]Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+
+	clear(m.peers)
+	m.publishPeers()
+	m.peersMutex.Unlock()
+
+	m.notifyStateChanges(forgotten...)
+	for _, peer := range peers {
+		m.notifyUnregistered(peer, EvictionStopped)
+	}
+}
+
+// Shutdown gracefully stops the communications: it stops the protocol
+// goroutines, sends the disconnect message to every registered peer and waits
+// for the send queues to drain.
+// If the context is done before the send queues are drained, the remaining
+// messages are discarded and the context's error is returned.
+// The communications are stopped in any case.
+//
+// The lifecycle is locked until Shutdown returns, so the calls to Start, Stop
+// and Close made meanwhile wait for the send queues to drain, or for the
+// context to be done. Bound the wait with the context.
+//
+// Like Stop, it returns ErrNotRunning if the CommsManager isn't running, and
+// ErrClosed if it's closed.
+func (m *CommsManager) Shutdown(ctx context.Context) error {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	if err := m.state.canStop(); err != nil {
+		return err
+	}
+
+	// Once the protocol goroutines are done, only the disconnect messages and
+	// the application's are queued
+	m.stopProtocol()
+	defer m.stopSending()
+
+	for _, peer := range m.Peers() {
+		err := m.send(ctx, []byte(disconnectMessage), m.peerAddress(peer.IP), PriorityControl)
+		if err != nil {
+			m.logger.Warn(
+				"Couldn't send disconnect",
+				slog.String("peer", peer.IP.String()),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	var (
+		queues  = m.closeSendQueues(false)
+		drained = make(chan struct{})
+	)
+
+	go func() {
+		m.sendersWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		for _, queue := range queues {
+			queue.close(true)
+		}
+		return ctx.Err()
+	}
 }
 
 // Close stops the communications (if they weren't already) and closes the
 // broadcast and unicast connections.
-func (m *CommsManager) Close() {
-	m.Stop()
+// A closed CommsManager can't be started again. Closing it twice returns
+// ErrClosed.
+func (m *CommsManager) Close() error {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	switch m.state {
+	case lifecycleClosed:
+		return ErrClosed
+	case lifecycleRunning:
+		m.stop()
+	}
+
 	m.broadcaster.Close()
 	m.unicaster.Close()
+	m.state = lifecycleClosed
+
+	return nil
 }
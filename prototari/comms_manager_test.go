@@ -1,8 +1,8 @@
 package prototari
 
 import (
+	"context"
 	"io"
-	"log"
 	"net"
 	"testing"
 	"time"
@@ -36,10 +36,6 @@ func TestCommsManager(t *testing.T) {
 		}
 	)
 
-	originalOutput := log.Writer()
-	log.SetOutput(io.Discard)
-	defer func() { log.SetOutput(originalOutput) }()
-
 	makePeers := func(
 		writtenMsgsChan,
 		broadCommsChan,
@@ -183,6 +179,10 @@ func TestCommsManager(t *testing.T) {
 		responderPeers := <-responder.PeersCh()
 		gotPeer = responderPeers[0]
 		assert.True(t, wantPeer.Equal(gotPeer))
+
+		// Check that both sides counted the completed handshake
+		assert.Equal(t, uint64(1), broadcaster.Metrics().HandshakesCompleted)
+		assert.Equal(t, uint64(1), responder.Metrics().HandshakesCompleted)
 	})
 
 	t.Run("Broadcaster ignores its own messages", func(t *testing.T) {
@@ -238,6 +238,45 @@ func TestCommsManager(t *testing.T) {
 		}
 	})
 
+	t.Run("Nearly full broadcaster advertises its free slots", func(t *testing.T) {
+		var (
+			writtenMsgsChan = make(chan fakeMsgRecord)
+			broadCh         = make(chan fakeMsgRecord, 1)
+			broadConn       = fakeBroadcastConn{
+				writeChan: broadCh,
+				written:   writtenMsgsChan,
+				localAddr: &broadcasterBroadAddr,
+			}
+			unicConn = fakeUnicastConn{
+				written:   writtenMsgsChan,
+				localAddr: &broadcasterUniAddr,
+			}
+			config = makeTestingConfig()
+		)
+		config.DiscoveryNearlyFull = 4
+
+		broadcaster := MakeManager(&broadConn, &unicConn, config)
+		broadcaster.Start()
+		defer func() {
+			close(broadCh)
+			close(writtenMsgsChan)
+			broadcaster.Stop()
+		}()
+
+		// The testing configuration allows a single peer
+		got := <-writtenMsgsChan
+		want := fakeMsgRecord{
+			IsUnicast: false,
+			From:      &broadcasterBroadAddr,
+			To: &net.UDPAddr{
+				IP:   []byte(fakeBroadcastAddr),
+				Port: BroadcastPort,
+			},
+			Payload: []byte(discoveryMessage + " 1"),
+		}
+		assert.Equal(t, want, got)
+	})
+
 	t.Run("Broadcaster with max peers registered doesn't send broadcast messages", func(t *testing.T) {
 		var (
 			writtenMsgsChan = make(chan fakeMsgRecord)
@@ -294,4 +333,308 @@ func TestCommsManager(t *testing.T) {
 			// Test passes. No message received in the timeout.
 		}
 	})
+
+	t.Run("Messages from registered peers are received", func(t *testing.T) {
+		var (
+			readCh    = make(chan fakeMsgRecord, 1)
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{
+				readChan:  readCh,
+				localAddr: &broadcasterUniAddr,
+			}
+			manager = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+			peer    = MakePeer([]byte(responderIP))
+		)
+
+		manager.registerPeer(peer)
+
+		manager.Start()
+		defer func() {
+			close(readCh)
+			manager.Stop()
+		}()
+
+		readCh <- fakeMsgRecord{From: &responderUniAddr, Payload: []byte("Hello")}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		message, err := manager.Receive(ctx)
+		assert.Nil(t, err)
+		assert.True(t, peer.Equal(message.From))
+		assert.Equal(t, []byte("Hello"), message.Payload)
+	})
+
+	t.Run("Disconnect message unregisters the peer", func(t *testing.T) {
+		var (
+			readCh    = make(chan fakeMsgRecord, 1)
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{
+				readChan:  readCh,
+				localAddr: &broadcasterUniAddr,
+			}
+			manager = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+		)
+
+		manager.registerPeer(MakePeer([]byte(responderIP)))
+		<-manager.PeersCh()
+
+		manager.Start()
+		defer func() {
+			close(readCh)
+			manager.Stop()
+		}()
+
+		readCh <- fakeMsgRecord{From: &responderUniAddr, Payload: []byte(disconnectMessage)}
+
+		peers := <-manager.PeersCh()
+		assert.Empty(t, peers)
+		assert.Equal(t, 0, manager.NOfPeers())
+	})
+
+	t.Run("Disconnecting a peer evicts it", func(t *testing.T) {
+		var (
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{localAddr: &broadcasterUniAddr}
+			manager   = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+		)
+
+		assert.ErrorIs(t, manager.Discover(), ErrNotRunning)
+
+		manager.registerPeer(MakePeer([]byte(responderIP)))
+
+		assert.Nil(t, manager.Disconnect([]byte(responderIP)))
+		assert.ErrorIs(t, manager.Disconnect([]byte(responderIP)), ErrUnknownPeer)
+		assert.Equal(t, 0, manager.NOfPeers())
+		assert.Equal(t, uint64(1), manager.Metrics().PeersEvicted)
+	})
+
+	t.Run("Lifecycle transitions", func(t *testing.T) {
+		var (
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{localAddr: &broadcasterUniAddr}
+			manager   = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+		)
+
+		assert.ErrorIs(t, manager.Stop(), ErrNotRunning)
+
+		assert.Nil(t, manager.Start())
+		assert.ErrorIs(t, manager.Start(), ErrRunning)
+
+		manager.registerPeer(MakePeer([]byte(responderIP)))
+		assert.Nil(t, manager.Stop())
+		assert.Equal(t, 0, manager.NOfPeers())
+		assert.ErrorIs(t, manager.Stop(), ErrNotRunning)
+
+		// A stopped manager can be started again
+		assert.Nil(t, manager.Start())
+
+		assert.Nil(t, manager.Close())
+		assert.ErrorIs(t, manager.Start(), ErrClosed)
+		assert.ErrorIs(t, manager.Stop(), ErrClosed)
+		assert.ErrorIs(t, manager.Close(), ErrClosed)
+	})
+
+	t.Run("Shutdown doesn't report the protocol messages it stops", func(t *testing.T) {
+		var (
+			writeCh   = make(chan fakeMsgRecord)
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{
+				writeChan: writeCh,
+				written:   make(chan fakeMsgRecord, 16),
+				localAddr: &broadcasterUniAddr,
+			}
+			config = makeTestingConfig()
+		)
+		// A heartbeat is due, or missed, at every check
+		config.InactivePeerTime = time.Millisecond
+		config.HeartbeatMaxWait = time.Millisecond
+		config.MaxMissedHeartbeats = 1000
+
+		manager := MakeManager(&broadConn, &unicConn, config)
+		manager.registerPeer(MakePeer([]byte(responderIP)))
+		assert.Nil(t, manager.Start())
+
+		// The writes are held, so that the heartbeats would be due while
+		// draining
+		<-writeCh
+		done := make(chan error)
+		go func() {
+			done <- manager.Shutdown(context.Background())
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		for draining := true; draining; {
+			select {
+			case <-writeCh:
+			case err := <-done:
+				assert.Nil(t, err)
+				draining = false
+			}
+		}
+
+		select {
+		case err := <-manager.Errors():
+			assert.Fail(t, "Shutdown reported an error", err)
+		default:
+		}
+	})
+
+	t.Run("Run returns fatal read errors", func(t *testing.T) {
+		var (
+			readCh    = make(chan fakeMsgRecord)
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{
+				readChan:  readCh,
+				localAddr: &broadcasterUniAddr,
+			}
+			manager = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+			errCh   = make(chan error)
+		)
+
+		go func() {
+			errCh <- manager.Run(context.Background())
+		}()
+
+		// A closed read channel emulates the connection being closed
+		close(readCh)
+
+		select {
+		case err := <-errCh:
+			assert.ErrorIs(t, err, io.EOF)
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Run didn't return")
+		}
+	})
+
+	t.Run("Rejected handshakes are reported", func(t *testing.T) {
+		var (
+			readCh    = make(chan fakeMsgRecord, 1)
+			broadConn = fakeBroadcastConn{localAddr: &broadcasterBroadAddr}
+			unicConn  = fakeUnicastConn{
+				readChan:  readCh,
+				localAddr: &broadcasterUniAddr,
+			}
+			manager = MakeManager(&broadConn, &unicConn, makeTestingConfig())
+			otherIP = "192.168.0.30"
+		)
+
+		// The testing configuration allows a single peer
+		manager.registerPeer(MakePeer([]byte(responderIP)))
+
+		manager.Start()
+		defer func() {
+			close(readCh)
+			manager.Stop()
+		}()
+
+		readCh <- fakeMsgRecord{
+			From:    &net.UDPAddr{IP: []byte(otherIP), Port: UnicastPort},
+			Payload: []byte(responseMessage),
+		}
+
+		select {
+		case err := <-manager.Errors():
+			var handshakeErr *HandshakeError
+			assert.ErrorAs(t, err, &handshakeErr)
+			assert.ErrorIs(t, err, ErrMaxPeers)
+			assert.Equal(t, HandshakeBroadcaster, handshakeErr.Role)
+			assert.True(t, handshakeErr.Peer.IP.Equal([]byte(otherIP)))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "No error was reported")
+		}
+
+		assert.Equal(t, uint64(1), manager.Metrics().HandshakesRejected)
+	})
+}
+
+func TestSimultaneousDiscovery(t *testing.T) {
+	var (
+		lowerAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		higherAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		makeManager = func(localAddr *net.UDPAddr) (*CommsManager, <-chan fakeMsgRecord, *registrationsObserver) {
+			var (
+				writeCh  = make(chan fakeMsgRecord, 16)
+				unicConn = fakeUnicastConn{
+					localAddr: localAddr,
+					writeChan: writeCh,
+					written:   make(chan fakeMsgRecord, 16),
+				}
+				observer = &registrationsObserver{}
+				config   = makeTestingConfig()
+			)
+			config.Observer = observer
+
+			manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, config)
+			manager.Start()
+
+			return manager, writeCh, observer
+		}
+		sent = func(writeCh <-chan fakeMsgRecord) []string {
+			var payloads []string
+			for {
+				select {
+				case msg := <-writeCh:
+					payloads = append(payloads, string(msg.Payload))
+				case <-time.After(50 * time.Millisecond):
+					return payloads
+				}
+			}
+		}
+	)
+
+	t.Run("The computer with the lower IP completes the handshake", func(t *testing.T) {
+		manager, writeCh, observer := makeManager(lowerAddr)
+		defer manager.Stop()
+
+		// It responded to the other's broadcast, and the other to its own
+		manager.advanceCandidate(higherAddr.IP, PeerHandshakePending)
+		assert.Nil(t, manager.completeHandshake(higherAddr, []byte(responseMessage)))
+
+		assert.True(t, manager.hasPeer(higherAddr.IP))
+		assert.Equal(t, []string{confirmationMessage}, sent(writeCh))
+
+		// The confirmation from the other, had it sent one, changes nothing
+		assert.Nil(t, manager.acceptHandshake(higherAddr, []byte(confirmationMessage)))
+		assert.Len(t, observer.registered, 1)
+		assert.Equal(t, uint64(1), manager.Metrics().HandshakesCompleted)
+	})
+
+	t.Run("The computer with the higher IP waits for the confirmation", func(t *testing.T) {
+		manager, writeCh, observer := makeManager(higherAddr)
+		defer manager.Stop()
+
+		manager.advanceCandidate(lowerAddr.IP, PeerHandshakePending)
+		assert.Nil(t, manager.completeHandshake(lowerAddr, []byte(responseMessage)))
+
+		assert.False(t, manager.hasPeer(lowerAddr.IP))
+		assert.Empty(t, sent(writeCh))
+
+		assert.Nil(t, manager.acceptHandshake(lowerAddr, []byte(confirmationMessage)))
+		assert.True(t, manager.hasPeer(lowerAddr.IP))
+		assert.Len(t, observer.registered, 1)
+	})
+
+	t.Run("Responses from registered peers are confirmed again", func(t *testing.T) {
+		manager, writeCh, observer := makeManager(higherAddr)
+		defer manager.Stop()
+
+		assert.Nil(t, manager.registerPeer(MakePeer(lowerAddr.IP)))
+		assert.Nil(t, manager.completeHandshake(lowerAddr, []byte(responseMessage)))
+
+		assert.Equal(t, []string{confirmationMessage}, sent(writeCh))
+		assert.Len(t, observer.registered, 1)
+	})
+
+	t.Run("Registering a peer twice publishes it once", func(t *testing.T) {
+		manager, _, observer := makeManager(lowerAddr)
+		defer manager.Stop()
+
+		assert.Nil(t, manager.registerPeer(MakePeer(higherAddr.IP)))
+		<-manager.PeersCh()
+		assert.Nil(t, manager.registerPeer(MakePeer(higherAddr.IP)))
+
+		assert.Empty(t, manager.PeersCh())
+		assert.Len(t, observer.registered, 1)
+	})
 }
@@ -1,15 +1,34 @@
 package prototari
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
 
 const (
 	defaultMaxPeers          int           = 64
 	defaultBroadcastInterval time.Duration = 5 * time.Second
+	defaultSendQueueCapacity int           = 64
+	defaultInactivePeerTime  time.Duration = 10 * time.Second
+	defaultHeartbeatMaxWait  time.Duration = time.Second
+	defaultMaxMissedBeats    int           = 3
+
+	defaultDiscoveryFastInterval   time.Duration = 500 * time.Millisecond
+	defaultDiscoveryFastBroadcasts int           = 3
+	defaultDiscoveryJitter         float64       = 0.2
+	defaultDiscoveryResponseDelay  time.Duration = 200 * time.Millisecond
+	defaultDiscoveryNearlyFull     int           = 4
 
 	BroadcastPort = 21451
 	UnicastPort   = 21450
 
-	connReadTimeout time.Duration = 200 * time.Millisecond
+	// minBroadcastInterval is the shortest broadcast interval accepted, so
+	// that the protocol doesn't flood the network.
+	minBroadcastInterval = 100 * time.Millisecond
 )
 
 // Config is the set of parameters that modify the protocol's behaviour.
@@ -18,22 +37,265 @@ type Config struct {
 	// accept. Once the maximum number of peers is registered, no more peers
 	// can be added.
 	MaxPeers int
-	// BroadcastInterval is the time between discovery broadcast messages.
+	// BroadcastInterval is the time between discovery broadcast messages,
+	// once the fast broadcasts are over.
 	BroadcastInterval time.Duration
+	// DiscoveryFastInterval is the time between the first discovery
+	// broadcasts, after starting and after losing every peer or calling
+	// Discover, so that the computer is found quickly. The interval then
+	// doubles with every broadcast up to the BroadcastInterval. Zero disables
+	// the fast broadcasts.
+	DiscoveryFastInterval time.Duration
+	// DiscoveryFastBroadcasts is the number of broadcasts sent every
+	// DiscoveryFastInterval before the interval starts doubling.
+	DiscoveryFastBroadcasts int
+	// DiscoveryJitter is the fraction of the interval between broadcasts by
+	// which each one is randomly shifted, earlier or later, so that computers
+	// started at once don't broadcast at once. The first broadcast, after
+	// starting and after Discover, is delayed by up to this fraction of the
+	// first interval. Zero disables it.
+	DiscoveryJitter float64
+	// DiscoveryResponseDelay is the longest a computer waits, a random time,
+	// before answering a discovery message, so that the broadcaster doesn't
+	// get the responses of every computer in the network at once. Zero
+	// answers right away.
+	DiscoveryResponseDelay time.Duration
+	// DiscoveryNearlyFull is the number of free slots, as advertised in the
+	// discovery messages, below which a broadcaster is nearly full. The
+	// broadcasters with no free slots aren't answered, and those nearly full
+	// are answered with a probability of their free slots over
+	// DiscoveryNearlyFull, so that they aren't sent more responses than they
+	// can take. Zero only skips the broadcasters with no free slots.
+	DiscoveryNearlyFull int
+	// InactivePeerTime is the time without hearing from a peer after which
+	// it's sent a heartbeat.
+	InactivePeerTime time.Duration
+	// HeartbeatMaxWait is the time a peer has to answer a heartbeat.
+	HeartbeatMaxWait time.Duration
+	// MaxMissedHeartbeats is the number of heartbeats in a row a peer can
+	// miss before it's considered disconnected and evicted, when there's no
+	// FailureDetector.
+	MaxMissedHeartbeats int
+	// FailureDetector decides which peers are suspect and which are
+	// disconnected. A nil detector suspects the peers that miss a heartbeat,
+	// and disconnects those that miss MaxMissedHeartbeats in a row.
+	FailureDetector FailureDetector
+	// IndirectProbes is the number of peers asked to probe a peer that didn't
+	// answer a heartbeat, before counting it as missed. Lossy links to a
+	// single peer are then told apart from the peer being down. The probes
+	// also spread the suspect and disconnected peers. Zero disables them.
+	IndirectProbes int
+	// BroadcastPort is the port the discovery messages are sent to.
+	// All the computers in the network must use the same port.
+	BroadcastPort int
+	// UnicastPort is the port the messages between peers are sent to.
+	// All the computers in the network must use the same port.
+	UnicastPort int
+	// SendQueueCapacity is the maximum number of messages of each priority
+	// class queued to be sent to a peer.
+	SendQueueCapacity int
+	// SendQueueOverflow decides what happens to the data messages sent to a
+	// peer whose send queue is full. Control messages always replace the
+	// oldest queued control message.
+	SendQueueOverflow OverflowPolicy
+	// EvictionPolicy decides which peers are evicted when MaxPeers is reduced
+	// below the number of registered peers. The pinned peers are never
+	// evicted this way.
+	EvictionPolicy EvictionPolicy
+	// FullPolicy decides what happens when a computer wants to be registered
+	// and MaxPeers are already registered: it's refused, or it replaces a
+	// registered peer. It doesn't apply when MaxPeers is reduced, which is
+	// what the EvictionPolicy is for.
+	FullPolicy FullPolicy
+	// Priority returns the priority of a computer for the
+	// FullEvictLowestPriority policy. The computers with a higher priority
+	// replace those with a lower one.
+	Priority func(IP net.IP) int
+	// PinnedPeers are the computers with a reserved slot among the MaxPeers:
+	// the rest of the peers can't take it, and they are never replaced.
+	PinnedPeers IPList
+	// Admit is the admission filter: only the computers whose IP it returns
+	// true for are registered as peers. A nil filter admits everyone.
+	Admit func(IP net.IP) bool
+	// RateLimits are the limits to the traffic sent and accepted.
+	// They're all disabled by default.
+	RateLimits RateLimits
+	// Logger is where the protocol logs to. A nil Logger discards the logs.
+	Logger *slog.Logger
+	// Observer is notified of the protocol events. It may be nil.
+	Observer Observer
 }
 
 // MakeDefaultConfig returns a configuration whose parameters are adjusted using
 // the protocol defined defaults.
 func MakeDefaultConfig() Config {
 	return Config{
-		MaxPeers:          defaultMaxPeers,
-		BroadcastInterval: time.Duration(defaultBroadcastInterval),
+		MaxPeers:                defaultMaxPeers,
+		BroadcastInterval:       time.Duration(defaultBroadcastInterval),
+		DiscoveryFastInterval:   defaultDiscoveryFastInterval,
+		DiscoveryFastBroadcasts: defaultDiscoveryFastBroadcasts,
+		DiscoveryJitter:         defaultDiscoveryJitter,
+		DiscoveryResponseDelay:  defaultDiscoveryResponseDelay,
+		DiscoveryNearlyFull:     defaultDiscoveryNearlyFull,
+		InactivePeerTime:        defaultInactivePeerTime,
+		HeartbeatMaxWait:        defaultHeartbeatMaxWait,
+		MaxMissedHeartbeats:     defaultMaxMissedBeats,
+		BroadcastPort:           BroadcastPort,
+		UnicastPort:             UnicastPort,
+		SendQueueCapacity:       defaultSendQueueCapacity,
+		SendQueueOverflow:       OverflowBlock,
 	}
 }
 
 func makeTestingConfig() Config {
 	return Config{
-		MaxPeers:          1,
-		BroadcastInterval: time.Duration(10 * time.Minute),
+		MaxPeers:            1,
+		BroadcastInterval:   time.Duration(10 * time.Minute),
+		InactivePeerTime:    10 * time.Minute,
+		HeartbeatMaxWait:    10 * time.Minute,
+		MaxMissedHeartbeats: 3,
+		BroadcastPort:       BroadcastPort,
+		UnicastPort:         UnicastPort,
+		SendQueueCapacity:   8,
+		SendQueueOverflow:   OverflowError,
+	}
+}
+
+// logger returns the configured logger, or one discarding the logs if there's
+// none.
+func (c Config) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return c.Logger
+}
+
+// admits checks whether the admission filter accepts the IP.
+func (c Config) admits(IP net.IP) bool {
+	return c.Admit == nil || c.Admit(IP)
+}
+
+// withDefaults returns the configuration with the parameters added after
+// MaxPeers and BroadcastInterval that can't be zero set to their defaults when
+// they are, so that the configurations written before they existed, like
+// Config{MaxPeers: 8, BroadcastInterval: time.Second}, keep working.
+func (c Config) withDefaults() Config {
+	defaults := MakeDefaultConfig()
+
+	orDefault(&c.InactivePeerTime, defaults.InactivePeerTime)
+	orDefault(&c.HeartbeatMaxWait, defaults.HeartbeatMaxWait)
+	orDefault(&c.MaxMissedHeartbeats, defaults.MaxMissedHeartbeats)
+	orDefault(&c.BroadcastPort, defaults.BroadcastPort)
+	orDefault(&c.UnicastPort, defaults.UnicastPort)
+	orDefault(&c.SendQueueCapacity, defaults.SendQueueCapacity)
+
+	return c
+}
+
+// orDefault sets the value to the default if it's zero.
+func orDefault[T comparable](value *T, def T) {
+	var zero T
+	if *value == zero {
+		*value = def
+	}
+}
+
+// Validate checks that the parameters make sense, returning an error
+// describing every one that doesn't.
+//
+// The parameters are checked as they are, but MakeUDPManager, MakeManager and
+// UpdateConfig first set those left at zero, other than MaxPeers and
+// BroadcastInterval, to their defaults when zero isn't valid for them.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.MaxPeers <= 0 {
+		errs = append(errs, fmt.Errorf("max peers must be positive, got %d", c.MaxPeers))
+	}
+	if c.BroadcastInterval < minBroadcastInterval {
+		errs = append(errs, fmt.Errorf(
+			"broadcast interval must be at least %s, got %s",
+			minBroadcastInterval,
+			c.BroadcastInterval,
+		))
+	}
+	if c.DiscoveryFastInterval != 0 && c.DiscoveryFastInterval < minBroadcastInterval {
+		errs = append(errs, fmt.Errorf(
+			"discovery fast interval must be zero or at least %s, got %s",
+			minBroadcastInterval,
+			c.DiscoveryFastInterval,
+		))
+	}
+	if c.DiscoveryFastBroadcasts < 0 {
+		errs = append(errs, fmt.Errorf("discovery fast broadcasts can't be negative, got %d", c.DiscoveryFastBroadcasts))
 	}
+	if c.DiscoveryJitter < 0 || c.DiscoveryJitter >= 1 {
+		errs = append(errs, fmt.Errorf("discovery jitter must be between 0 and 1, got %g", c.DiscoveryJitter))
+	}
+	if c.DiscoveryResponseDelay < 0 {
+		errs = append(errs, fmt.Errorf("discovery response delay can't be negative, got %s", c.DiscoveryResponseDelay))
+	}
+	if c.DiscoveryNearlyFull < 0 {
+		errs = append(errs, fmt.Errorf("discovery nearly full can't be negative, got %d", c.DiscoveryNearlyFull))
+	}
+	if c.InactivePeerTime <= 0 {
+		errs = append(errs, fmt.Errorf("inactive peer time must be positive, got %s", c.InactivePeerTime))
+	}
+	if c.HeartbeatMaxWait <= 0 {
+		errs = append(errs, fmt.Errorf("heartbeat max wait must be positive, got %s", c.HeartbeatMaxWait))
+	}
+	if c.MaxMissedHeartbeats <= 0 {
+		errs = append(errs, fmt.Errorf("max missed heartbeats must be positive, got %d", c.MaxMissedHeartbeats))
+	}
+	if c.IndirectProbes < 0 {
+		errs = append(errs, fmt.Errorf("indirect probes can't be negative, got %d", c.IndirectProbes))
+	}
+
+	errs = append(errs, validatePort("broadcast", c.BroadcastPort), validatePort("unicast", c.UnicastPort))
+	if c.BroadcastPort == c.UnicastPort {
+		errs = append(errs, fmt.Errorf("broadcast and unicast ports must differ, both are %d", c.UnicastPort))
+	}
+
+	if c.SendQueueCapacity <= 0 {
+		errs = append(errs, fmt.Errorf("send queue capacity must be positive, got %d", c.SendQueueCapacity))
+	}
+	if c.SendQueueOverflow < OverflowBlock || c.SendQueueOverflow > OverflowError {
+		errs = append(errs, fmt.Errorf("unknown send queue overflow policy %d", c.SendQueueOverflow))
+	}
+	if c.EvictionPolicy < EvictLeastRecentlySeen || c.EvictionPolicy > EvictNewest {
+		errs = append(errs, fmt.Errorf("unknown eviction policy %d", c.EvictionPolicy))
+	}
+	if c.FullPolicy < FullRefuse || c.FullPolicy > FullEvictLowestPriority {
+		errs = append(errs, fmt.Errorf("unknown full policy %d", c.FullPolicy))
+	}
+	if c.FullPolicy == FullEvictLowestPriority && c.Priority == nil {
+		errs = append(errs, errors.New("the evict-lowest-priority full policy needs a priority function"))
+	}
+	if len(c.PinnedPeers) > c.MaxPeers {
+		errs = append(errs, fmt.Errorf(
+			"pinned peers can't be more than max peers (%d), got %d",
+			c.MaxPeers,
+			len(c.PinnedPeers),
+		))
+	}
+
+	errs = append(
+		errs,
+		c.RateLimits.PeerPackets.validate("peer packets"),
+		c.RateLimits.PeerBytes.validate("peer bytes"),
+		c.RateLimits.GlobalPackets.validate("global packets"),
+		c.RateLimits.GlobalBytes.validate("global bytes"),
+		c.RateLimits.InboundPackets.validate("inbound packets"),
+	)
+
+	return errors.Join(errs...)
+}
+
+func validatePort(name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("%s port must be between 1 and 65535, got %d", name, port)
+	}
+
+	return nil
 }
@@ -0,0 +1,253 @@
+package prototari
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix of the environment variables overriding the
+// configuration parameters.
+const envPrefix = "PELOTARI_"
+
+// A configSetting is a configuration parameter that can be set from a file or
+// an environment variable.
+type configSetting struct {
+	// name is the key of the parameter in the files. The environment
+	// variable is named after it: max-peers is PELOTARI_MAX_PEERS.
+	name string
+	// set parses the value and sets the parameter.
+	set func(c *Config, value string) error
+}
+
+// envName returns the name of the setting's environment variable.
+func (s configSetting) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var configSettings = []configSetting{
+	intSetting("max-peers", func(c *Config) *int { return &c.MaxPeers }),
+	durationSetting("broadcast-interval", func(c *Config) *time.Duration { return &c.BroadcastInterval }),
+	durationSetting("discovery-fast-interval", func(c *Config) *time.Duration { return &c.DiscoveryFastInterval }),
+	intSetting("discovery-fast-broadcasts", func(c *Config) *int { return &c.DiscoveryFastBroadcasts }),
+	floatSetting("discovery-jitter", func(c *Config) *float64 { return &c.DiscoveryJitter }),
+	durationSetting("discovery-response-delay", func(c *Config) *time.Duration { return &c.DiscoveryResponseDelay }),
+	intSetting("discovery-nearly-full", func(c *Config) *int { return &c.DiscoveryNearlyFull }),
+	durationSetting("inactive-peer-time", func(c *Config) *time.Duration { return &c.InactivePeerTime }),
+	durationSetting("heartbeat-max-wait", func(c *Config) *time.Duration { return &c.HeartbeatMaxWait }),
+	intSetting("max-missed-heartbeats", func(c *Config) *int { return &c.MaxMissedHeartbeats }),
+	intSetting("indirect-probes", func(c *Config) *int { return &c.IndirectProbes }),
+	{"failure-detector", func(c *Config, value string) (err error) {
+		c.FailureDetector, err = FailureDetectorNamed(value)
+		return err
+	}},
+	intSetting("broadcast-port", func(c *Config) *int { return &c.BroadcastPort }),
+	intSetting("unicast-port", func(c *Config) *int { return &c.UnicastPort }),
+	intSetting("send-queue-capacity", func(c *Config) *int { return &c.SendQueueCapacity }),
+	textSetting("send-queue-overflow", func(c *Config) encoding.TextUnmarshaler { return &c.SendQueueOverflow }),
+	textSetting("eviction-policy", func(c *Config) encoding.TextUnmarshaler { return &c.EvictionPolicy }),
+	textSetting("full-policy", func(c *Config) encoding.TextUnmarshaler { return &c.FullPolicy }),
+	textSetting("pinned-peers", func(c *Config) encoding.TextUnmarshaler { return &c.PinnedPeers }),
+	floatSetting("peer-packets-rate", func(c *Config) *float64 { return &c.RateLimits.PeerPackets.Rate }),
+	intSetting("peer-packets-burst", func(c *Config) *int { return &c.RateLimits.PeerPackets.Burst }),
+	floatSetting("peer-bytes-rate", func(c *Config) *float64 { return &c.RateLimits.PeerBytes.Rate }),
+	intSetting("peer-bytes-burst", func(c *Config) *int { return &c.RateLimits.PeerBytes.Burst }),
+	floatSetting("global-packets-rate", func(c *Config) *float64 { return &c.RateLimits.GlobalPackets.Rate }),
+	intSetting("global-packets-burst", func(c *Config) *int { return &c.RateLimits.GlobalPackets.Burst }),
+	floatSetting("global-bytes-rate", func(c *Config) *float64 { return &c.RateLimits.GlobalBytes.Rate }),
+	intSetting("global-bytes-burst", func(c *Config) *int { return &c.RateLimits.GlobalBytes.Burst }),
+	floatSetting("inbound-packets-rate", func(c *Config) *float64 { return &c.RateLimits.InboundPackets.Rate }),
+	intSetting("inbound-packets-burst", func(c *Config) *int { return &c.RateLimits.InboundPackets.Burst }),
+}
+
+func intSetting(name string, field func(*Config) *int) configSetting {
+	return configSetting{name, func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q isn't an integer", value)
+		}
+
+		*field(c) = n
+		return nil
+	}}
+}
+
+func floatSetting(name string, field func(*Config) *float64) configSetting {
+	return configSetting{name, func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", value)
+		}
+
+		*field(c) = f
+		return nil
+	}}
+}
+
+func durationSetting(name string, field func(*Config) *time.Duration) configSetting {
+	return configSetting{name, func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q isn't a duration, like 5s or 1m30s", value)
+		}
+
+		*field(c) = d
+		return nil
+	}}
+}
+
+func textSetting(name string, field func(*Config) encoding.TextUnmarshaler) configSetting {
+	return configSetting{name, func(c *Config, value string) error {
+		return field(c).UnmarshalText([]byte(value))
+	}}
+}
+
+// set sets the parameter with the given name.
+func (c *Config) set(name, value string) error {
+	for _, setting := range configSettings {
+		if setting.name == name {
+			return setting.set(c, value)
+		}
+	}
+
+	return errors.New("unknown parameter")
+}
+
+// LoadConfig returns the default configuration, overridden by the parameters in
+// the file at the given path, if not empty, and then by the PELOTARI_*
+// environment variables. The resulting configuration is validated.
+func LoadConfig(path string) (Config, error) {
+	config := MakeDefaultConfig()
+
+	if path != "" {
+		if err := config.LoadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := config.LoadEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// LoadFile sets the parameters in the file at the given path.
+//
+// Files with the .json extension contain a JSON object, where durations and
+// the overflow policy are strings:
+//
+//	{"max-peers": 16, "broadcast-interval": "10s"}
+//
+// Any other file has a parameter per line, with blank lines and lines starting
+// with # ignored:
+//
+//	max-peers = 16
+//	broadcast-interval = 10s
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = c.loadJSON(data)
+	} else {
+		err = c.loadKeyValues(data)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadJSON sets the parameters of a JSON object.
+func (c *Config) loadJSON(data []byte) error {
+	var (
+		decoder = json.NewDecoder(bytes.NewReader(data))
+		params  map[string]any
+	)
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&params); err != nil {
+		return err
+	}
+
+	for name, value := range params {
+		var text string
+
+		switch value := value.(type) {
+		case string:
+			text = value
+		case json.Number:
+			text = value.String()
+		default:
+			return fmt.Errorf("%s: must be a number or a string", name)
+		}
+
+		if err := c.set(name, text); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadKeyValues sets the parameters of key = value lines.
+func (c *Config) loadKeyValues(data []byte) error {
+	var (
+		scanner = bufio.NewScanner(bytes.NewReader(data))
+		lineNo  = 0
+	)
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected name = value", lineNo)
+		}
+
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		if err := c.set(name, value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineNo, name, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// LoadEnv sets the parameters given by the PELOTARI_* environment variables,
+// like PELOTARI_MAX_PEERS or PELOTARI_BROADCAST_INTERVAL.
+func (c *Config) LoadEnv() error {
+	for _, setting := range configSettings {
+		value, ok := os.LookupEnv(setting.envName())
+		if !ok {
+			continue
+		}
+
+		if err := setting.set(c, value); err != nil {
+			return fmt.Errorf("%s: %w", setting.envName(), err)
+		}
+	}
+
+	return nil
+}
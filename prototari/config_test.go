@@ -0,0 +1,132 @@
+package prototari
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	writeFile := func(t *testing.T, name, content string) string {
+		path := filepath.Join(t.TempDir(), name)
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("The default configuration is valid", func(t *testing.T) {
+		assert.Nil(t, MakeDefaultConfig().Validate())
+	})
+
+	t.Run("Validation describes every invalid parameter", func(t *testing.T) {
+		config := MakeDefaultConfig()
+		config.MaxPeers = 0
+		config.BroadcastInterval = time.Millisecond
+		config.UnicastPort = 70000
+		config.RateLimits.PeerBytes.Rate = -1
+
+		err := config.Validate()
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "max peers must be positive")
+			assert.Contains(t, err.Error(), "broadcast interval must be at least")
+			assert.Contains(t, err.Error(), "unicast port must be between 1 and 65535")
+			assert.Contains(t, err.Error(), "peer bytes rate limit can't be negative")
+		}
+	})
+
+	t.Run("Configurations with only the original parameters get the defaults", func(t *testing.T) {
+		var (
+			config   = Config{MaxPeers: 8, BroadcastInterval: time.Second}
+			conn     = fakeUnicastConn{localAddr: &net.UDPAddr{IP: net.ParseIP("192.168.0.10")}}
+			manager  = MakeManager(&fakeBroadcastConn{}, &conn, config)
+			defaults = MakeDefaultConfig()
+		)
+
+		assert.NotNil(t, config.Validate())
+		assert.Nil(t, manager.Config().Validate())
+		assert.Equal(t, 8, manager.Config().MaxPeers)
+		assert.Equal(t, defaults.UnicastPort, manager.Config().UnicastPort)
+		assert.Equal(t, defaults.InactivePeerTime, manager.Config().InactivePeerTime)
+	})
+
+	t.Run("Load a JSON file", func(t *testing.T) {
+		path := writeFile(t, "pelotari.json", `{
+			"max-peers": 16,
+			"broadcast-interval": "10s",
+			"send-queue-overflow": "drop-oldest",
+			"peer-packets-rate": 2.5
+		}`)
+
+		config, err := LoadConfig(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 16, config.MaxPeers)
+		assert.Equal(t, 10*time.Second, config.BroadcastInterval)
+		assert.Equal(t, OverflowDropOldest, config.SendQueueOverflow)
+		assert.Equal(t, 2.5, config.RateLimits.PeerPackets.Rate)
+		assert.Equal(t, UnicastPort, config.UnicastPort)
+	})
+
+	t.Run("Load a key=value file", func(t *testing.T) {
+		path := writeFile(t, "pelotari.conf", `
+			# Ports for the lab network
+			broadcast-port = 31451
+			unicast-port = "31450"
+		`)
+
+		config, err := LoadConfig(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 31451, config.BroadcastPort)
+		assert.Equal(t, 31450, config.UnicastPort)
+	})
+
+	t.Run("Select the failure detector by name", func(t *testing.T) {
+		t.Setenv("PELOTARI_FAILURE_DETECTOR", "phi-accrual")
+
+		config, err := LoadConfig("")
+
+		assert.Nil(t, err)
+		assert.IsType(t, &PhiAccrualDetector{}, config.FailureDetector)
+	})
+
+	t.Run("Pin peers and choose the full policy", func(t *testing.T) {
+		path := writeFile(t, "pelotari.conf", `
+			full-policy = evict-worst-link
+			pinned-peers = 192.168.0.1, 192.168.0.2
+		`)
+
+		config, err := LoadConfig(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, FullEvictWorstLink, config.FullPolicy)
+		assert.Equal(t, IPList{net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")}, config.PinnedPeers)
+	})
+
+	t.Run("Environment variables override the file", func(t *testing.T) {
+		path := writeFile(t, "pelotari.conf", "max-peers = 16\n")
+		t.Setenv("PELOTARI_MAX_PEERS", "4")
+
+		config, err := LoadConfig(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 4, config.MaxPeers)
+	})
+
+	t.Run("Errors point to the wrong parameter", func(t *testing.T) {
+		path := writeFile(t, "pelotari.conf", "max-peers = 16\nbroadcast-interval = often\n")
+		_, err := LoadConfig(path)
+		assert.ErrorContains(t, err, "line 2: broadcast-interval")
+
+		path = writeFile(t, "pelotari.json", `{"max-pears": 16}`)
+		_, err = LoadConfig(path)
+		assert.ErrorContains(t, err, "max-pears: unknown parameter")
+
+		t.Setenv("PELOTARI_UNICAST_PORT", "0")
+		_, err = LoadConfig("")
+		assert.ErrorContains(t, err, "unicast port must be between 1 and 65535")
+	})
+}
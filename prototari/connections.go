@@ -1,7 +1,10 @@
 package prototari
 
 import (
+	"errors"
+	"io"
 	"net"
+	"time"
 )
 
 // A BroadcastConn is the connection used to send and receive broadcast messages
@@ -16,8 +19,14 @@ type BroadcastConn interface {
 
 	// Read receives broadcast messages from the local network.
 	// The number of read bytes and the UDP address of the sender are returned.
+	// It blocks until a message arrives or the read deadline is reached.
 	Read(b []byte) (int, *net.UDPAddr, error)
 
+	// SetReadDeadline sets the deadline for the pending and future Read calls.
+	// Setting a deadline in the past unblocks a pending Read, which returns a
+	// timeout error. A zero value means Read won't time out.
+	SetReadDeadline(t time.Time) error
+
 	// Close closes the broadcast connections.
 	Close()
 }
@@ -34,8 +43,27 @@ type UnicastConn interface {
 
 	// Read receives unicast messages from peers on the local network.
 	// The number of read bytes and the UDP address of the sender are returned.
+	// It blocks until a message arrives or the read deadline is reached.
 	Read(b []byte) (int, *net.UDPAddr, error)
 
+	// SetReadDeadline sets the deadline for the pending and future Read calls.
+	// Setting a deadline in the past unblocks a pending Read, which returns a
+	// timeout error. A zero value means Read won't time out.
+	SetReadDeadline(t time.Time) error
+
 	// Close closes the unicast connections.
 	Close()
 }
+
+// isTimeoutErr checks whether a read error is due to the read deadline being
+// reached.
+func isTimeoutErr(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isFatalReadErr checks whether a read error means the connection can't be
+// read from anymore.
+func isFatalReadErr(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF)
+}
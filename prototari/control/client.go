@@ -0,0 +1,168 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// A Client talks to a pelotari daemon through its Unix domain socket.
+// It's safe for concurrent use; the requests are sent one at a time.
+type Client struct {
+	path string
+
+	conn    net.Conn
+	scanner *bufio.Scanner
+	mutex   sync.Mutex
+}
+
+// Dial connects to the daemon listening on the socket at the given path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		path:    path,
+		conn:    conn,
+		scanner: newScanner(conn),
+	}, nil
+}
+
+// Close closes the connection to the daemon.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Peers returns the peers registered by the daemon.
+func (c *Client) Peers(ctx context.Context) ([]Peer, error) {
+	res, err := c.do(ctx, request{Op: opPeers})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Peers, nil
+}
+
+// SendMessage has the daemon send a message to all its peers.
+func (c *Client) SendMessage(ctx context.Context, payload []byte) error {
+	_, err := c.do(ctx, request{Op: opSend, Payload: payload})
+	return err
+}
+
+// SendMessageTo has the daemon send a message to one of its peers.
+// The errors from the daemon wrap the prototari errors, so it can be checked
+// whether the peer is unknown using errors.Is(err, prototari.ErrUnknownPeer).
+func (c *Client) SendMessageTo(ctx context.Context, IP net.IP, payload []byte) error {
+	if IP == nil {
+		return prototari.ErrUnknownPeer
+	}
+
+	_, err := c.do(ctx, request{Op: opSend, To: IP, Payload: payload})
+	return err
+}
+
+// do sends a request and reads its response.
+// The context interrupts the exchange by closing the connection, as the
+// response can't be told apart from the next one otherwise.
+func (c *Client) do(ctx context.Context, req request) (response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return response{}, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+
+	var res response
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return response{}, contextErr(ctx, err)
+	}
+	if err := readJSON(c.scanner, &res); err != nil {
+		return response{}, contextErr(ctx, err)
+	}
+
+	return res, res.err()
+}
+
+// Subscribe opens a new connection to the daemon streaming its events until
+// the context is done, when the returned channel is closed.
+// Events are discarded by the daemon if they aren't read fast enough.
+func (c *Client) Subscribe(ctx context.Context) (<-chan Event, error) {
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		scanner = newScanner(conn)
+		res     response
+	)
+
+	if err := json.NewEncoder(conn).Encode(request{Op: opSubscribe}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := readJSON(scanner, &res); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := res.err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		for {
+			var event Event
+			if err := readJSON(scanner, &event); err != nil {
+				return
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// readJSON decodes the next line from the scanner into v.
+func readJSON(scanner *bufio.Scanner, v any) error {
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("connection closed by the daemon")
+	}
+
+	return json.Unmarshal(scanner.Bytes(), v)
+}
+
+// contextErr returns the context's error if it's done, as it's the reason the
+// connection was closed, or err otherwise.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
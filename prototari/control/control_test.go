@@ -0,0 +1,182 @@
+package control
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNode struct {
+	peers    []prototari.Peer
+	messages chan prototari.Message
+
+	mutex sync.Mutex
+	sent  map[string][]byte
+}
+
+func (n *fakeNode) Peers() []prototari.Peer {
+	return n.peers
+}
+
+func (n *fakeNode) SendMessage(ctx context.Context, payload []byte) error {
+	for _, peer := range n.peers {
+		n.SendMessageTo(ctx, peer, payload)
+	}
+	return nil
+}
+
+func (n *fakeNode) SendMessageTo(ctx context.Context, peer prototari.Peer, payload []byte) error {
+	for _, p := range n.peers {
+		if p.Equal(peer) {
+			n.mutex.Lock()
+			n.sent[peer.IP.String()] = payload
+			n.mutex.Unlock()
+			return nil
+		}
+	}
+	return prototari.ErrUnknownPeer
+}
+
+func (n *fakeNode) Receive(ctx context.Context) (prototari.Message, error) {
+	select {
+	case message := <-n.messages:
+		return message, nil
+	case <-ctx.Done():
+		return prototari.Message{}, ctx.Err()
+	}
+}
+
+func TestControl(t *testing.T) {
+	var (
+		peerIP = net.ParseIP("192.168.0.2")
+		node   = &fakeNode{
+			peers:    []prototari.Peer{prototari.MakePeer(peerIP)},
+			messages: make(chan prototari.Message),
+			sent:     make(map[string][]byte),
+		}
+		server = NewServer(nil)
+	)
+
+	// Unix socket paths are limited to around a hundred characters
+	dir, err := os.MkdirTemp("", "pelotari")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pelotari.sock")
+
+	listener, err := Listen(path)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- server.Serve(ctx, listener, node)
+	}()
+	defer func() {
+		cancel()
+		assert.Nil(t, <-served)
+	}()
+
+	client, err := Dial(path)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	t.Run("List peers", func(t *testing.T) {
+		peers, err := client.Peers(ctx)
+
+		assert.Nil(t, err)
+		if assert.Len(t, peers, 1) {
+			assert.True(t, peerIP.Equal(peers[0].IP))
+		}
+	})
+
+	t.Run("Send a message to a peer", func(t *testing.T) {
+		assert.Nil(t, client.SendMessageTo(ctx, peerIP, []byte("kaixo")))
+
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		assert.Equal(t, []byte("kaixo"), node.sent[peerIP.String()])
+	})
+
+	t.Run("Sending to an unknown peer", func(t *testing.T) {
+		err := client.SendMessageTo(ctx, net.ParseIP("192.168.0.3"), []byte("kaixo"))
+		assert.ErrorIs(t, err, prototari.ErrUnknownPeer)
+	})
+
+	t.Run("Subscribers receive messages and peer events", func(t *testing.T) {
+		subCtx, cancelSub := context.WithCancel(ctx)
+		defer cancelSub()
+
+		events, err := client.Subscribe(subCtx)
+		assert.Nil(t, err)
+
+		receive := func() Event {
+			select {
+			case event := <-events:
+				assert.True(t, peerIP.Equal(event.Peer.IP))
+				return event
+			case <-time.After(time.Second):
+				assert.FailNow(t, "Event not received")
+				return Event{}
+			}
+		}
+
+		node.messages <- prototari.Message{From: node.peers[0], Payload: []byte("kaixo")}
+		event := receive()
+		assert.Equal(t, EventMessage, event.Type)
+		assert.Equal(t, []byte("kaixo"), event.Payload)
+
+		server.OnPeerEvicted(node.peers[0], prototari.EvictionDisconnected)
+		event = receive()
+		assert.Equal(t, EventPeerEvicted, event.Type)
+		assert.Equal(t, "disconnected", event.Reason)
+
+		cancelSub()
+		for range events {
+		}
+	})
+
+	t.Run("A second daemon can't listen on the same socket", func(t *testing.T) {
+		_, err := Listen(path)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Only the user can connect to the socket", func(t *testing.T) {
+		info, err := os.Stat(path)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+}
+
+func TestListen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "pelotari")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("The socket's directory is created for the user", func(t *testing.T) {
+		socketDir := filepath.Join(dir, "run")
+
+		listener, err := Listen(filepath.Join(socketDir, "pelotari.sock"))
+		assert.Nil(t, err)
+		defer listener.Close()
+
+		info, err := os.Stat(socketDir)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	})
+
+	t.Run("Directories other users can write to are refused", func(t *testing.T) {
+		shared := filepath.Join(dir, "shared")
+		assert.Nil(t, os.Mkdir(shared, 0o700))
+		assert.Nil(t, os.Chmod(shared, 0o777))
+
+		_, err := Listen(filepath.Join(shared, "pelotari.sock"))
+		assert.ErrorContains(t, err, "other users can write")
+	})
+}
@@ -0,0 +1,175 @@
+// Package control implements the local API of a pelotari daemon: a single
+// process owns the protocol's sockets and serves the other applications in the
+// same computer through a Unix domain socket.
+//
+// The API exchanges JSON values, one per line. The client sends a request and
+// the server answers with a response. A subscribe request turns the connection
+// into a stream of events, which the server writes until the client closes it.
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// DefaultSocketPath is the path of the daemon's socket when none is given.
+// It's in the user's runtime directory or, when there's none, in a directory
+// of the user's own in the temporary one, so that other users can't replace
+// the socket.
+var DefaultSocketPath = defaultSocketPath()
+
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pelotari.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("pelotari-%d", os.Getuid()), "pelotari.sock")
+}
+
+// The operations a request can ask for.
+const (
+	opPeers     = "peers"
+	opSend      = "send"
+	opSubscribe = "subscribe"
+)
+
+// The error codes identifying the errors the client maps back to the
+// prototari errors.
+const (
+	codeUnknownPeer = "unknown-peer"
+	codeQueueFull   = "queue-full"
+	codeQueueClosed = "queue-closed"
+)
+
+var codeErrors = map[string]error{
+	codeUnknownPeer: prototari.ErrUnknownPeer,
+	codeQueueFull:   prototari.ErrQueueFull,
+	codeQueueClosed: prototari.ErrQueueClosed,
+}
+
+// errorCode returns the code of the error, or an empty string if it's not one
+// of the errors known by the client.
+func errorCode(err error) string {
+	for code, codeErr := range codeErrors {
+		if errors.Is(err, codeErr) {
+			return code
+		}
+	}
+
+	return ""
+}
+
+// The types of the events streamed to the subscribers.
+const (
+	// EventMessage is the type of the events for the messages received from
+	// the peers.
+	EventMessage = "message"
+	// EventPeerRegistered is the type of the events for the registered peers.
+	EventPeerRegistered = "peer-registered"
+	// EventPeerEvicted is the type of the events for the removed peers.
+	EventPeerEvicted = "peer-evicted"
+	// EventPeerState is the type of the events for the computers moving to
+	// another stage of their lifecycle as peers.
+	EventPeerState = "peer-state"
+)
+
+// A Peer is the description of a peer sent through the API.
+type Peer struct {
+	IP               net.IP              `json:"ip"`
+	LastSeen         time.Time           `json:"lastSeen"`
+	MissedHeartbeats int                 `json:"missedHeartbeats"`
+	State            prototari.PeerState `json:"state"`
+	Link             prototari.LinkStats `json:"link"`
+	ClockOffset      time.Duration       `json:"clockOffset"`
+	ClockUncertainty time.Duration       `json:"clockUncertainty"`
+}
+
+func makePeer(peer prototari.Peer) Peer {
+	return Peer{
+		IP:               peer.IP,
+		LastSeen:         peer.LastSeen,
+		MissedHeartbeats: peer.MissedHeartbeats,
+		State:            peer.State,
+		Link:             peer.Link,
+		ClockOffset:      peer.ClockOffset,
+		ClockUncertainty: peer.ClockUncertainty,
+	}
+}
+
+// An Event is something that happened in the daemon's protocol.
+type Event struct {
+	// Type is one of EventMessage, EventPeerRegistered, EventPeerEvicted or
+	// EventPeerState.
+	Type string `json:"type"`
+	// Peer is the peer the message is from, the registered or evicted peer,
+	// or the computer that changed state, already in the new one.
+	Peer Peer `json:"peer"`
+	// Payload is the received message's payload.
+	Payload []byte `json:"payload,omitempty"`
+	// Reason is why the peer was evicted.
+	Reason string `json:"reason,omitempty"`
+	// From is the state the computer left, omitted when it's disconnected.
+	From prototari.PeerState `json:"from,omitempty"`
+}
+
+type request struct {
+	Op string `json:"op"`
+	// To is the IP of the peer to send the message to, or nil to send it to
+	// all peers.
+	To      net.IP `json:"to,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+type response struct {
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Peers []Peer `json:"peers,omitempty"`
+}
+
+// err returns the error in the response, if any.
+func (r response) err() error {
+	if r.Error == "" {
+		return nil
+	}
+
+	if codeErr, ok := codeErrors[r.Code]; ok {
+		return &RemoteError{Message: r.Error, err: codeErr}
+	}
+
+	return &RemoteError{Message: r.Error}
+}
+
+// A RemoteError is an error returned by the daemon.
+// It wraps the prototari errors the client recognizes, like ErrUnknownPeer.
+type RemoteError struct {
+	Message string
+	err     error
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+func (e *RemoteError) Unwrap() error {
+	return e.err
+}
+
+// maxLineSize is the size of the longest line exchanged through the API. It
+// fits the largest UDP payload, base64 encoded.
+const maxLineSize = 128 * 1024
+
+// newScanner returns a scanner of the lines read from r.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+
+	return scanner
+}
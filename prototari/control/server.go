@@ -0,0 +1,306 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/angelsolaorbaiceta/prototari/prototari"
+)
+
+// subscriberCapacity is the number of events buffered for a subscriber before
+// the new ones are discarded.
+const subscriberCapacity = 64
+
+// A Node is the part of the CommsManager the server exposes.
+type Node interface {
+	Peers() []prototari.Peer
+	SendMessage(ctx context.Context, payload []byte) error
+	SendMessageTo(ctx context.Context, peer prototari.Peer, payload []byte) error
+	Receive(ctx context.Context) (prototari.Message, error)
+}
+
+// A Server serves the local API of a node.
+//
+// The server is also an Observer, which has to be included in the node's
+// configuration for the subscribers to be notified of the peer events:
+//
+//	server := control.NewServer(logger)
+//	config.Observer = prototari.MultiObserver(config.Observer, server)
+type Server struct {
+	prototari.NoopObserver
+
+	logger *slog.Logger
+
+	subscribers      map[chan Event]struct{}
+	subscribersMutex sync.Mutex
+}
+
+// NewServer returns a server logging to the given logger, which may be nil.
+func NewServer(logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	return &Server{
+		logger:      logger,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Listen opens the Unix domain socket at the given path, replacing a stale
+// socket file left by a previous daemon. Only the user can connect to it.
+//
+// The socket's directory is created, only accessible by the user, if it
+// doesn't exist. Directories other users can write to, other than those with
+// the sticky bit set, like /tmp, are refused, as they could replace the socket.
+func Listen(path string) (net.Listener, error) {
+	if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	// A socket nobody listens on is left behind when a daemon crashes
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("a daemon is already listening on " + path)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// checkSocketDir creates the directory of a socket if it doesn't exist, and
+// checks that other users can't replace the files in it.
+func checkSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if mode := info.Mode(); mode.Perm()&0o022 != 0 && mode&os.ModeSticky == 0 {
+		return fmt.Errorf("other users can write to the socket directory %s", dir)
+	}
+
+	return nil
+}
+
+// Serve accepts connections from the listener and serves them until the
+// context is done, at which point the listener is closed.
+// Meanwhile, the messages received by the node are streamed to the
+// subscribers, so no one else should call the node's Receive.
+func (s *Server) Serve(ctx context.Context, listener net.Listener, node Node) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.forwardMessages(ctx, node)
+	}()
+
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn, node)
+		}()
+	}
+}
+
+// forwardMessages publishes the messages received by the node until the
+// context is done.
+func (s *Server) forwardMessages(ctx context.Context, node Node) {
+	for {
+		message, err := node.Receive(ctx)
+		if err != nil {
+			return
+		}
+
+		s.publish(Event{
+			Type:    EventMessage,
+			Peer:    makePeer(message.From),
+			Payload: message.Payload,
+		})
+	}
+}
+
+// serveConn answers the requests in the connection until the client closes it
+// or the context is done.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, node Node) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var (
+		scanner = newScanner(conn)
+		encoder = json.NewEncoder(conn)
+	)
+
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			encoder.Encode(response{Error: "invalid request: " + err.Error()})
+			return
+		}
+
+		if req.Op == opSubscribe {
+			if err := encoder.Encode(response{}); err == nil {
+				s.stream(ctx, conn, encoder)
+			}
+			return
+		}
+
+		if err := encoder.Encode(s.handle(ctx, req, node)); err != nil {
+			s.logger.Debug("Writing control response failed", slog.Any("error", err))
+			return
+		}
+	}
+}
+
+// handle executes a request.
+func (s *Server) handle(ctx context.Context, req request, node Node) response {
+	var err error
+
+	switch req.Op {
+	case opPeers:
+		var (
+			peers = node.Peers()
+			res   = response{Peers: make([]Peer, 0, len(peers))}
+		)
+		for _, peer := range peers {
+			res.Peers = append(res.Peers, makePeer(peer))
+		}
+		return res
+
+	case opSend:
+		if req.To == nil {
+			err = node.SendMessage(ctx, req.Payload)
+		} else {
+			err = node.SendMessageTo(ctx, prototari.Peer{IP: req.To}, req.Payload)
+		}
+
+	default:
+		err = errors.New("unknown operation " + req.Op)
+	}
+
+	if err != nil {
+		return response{Error: err.Error(), Code: errorCode(err)}
+	}
+
+	return response{}
+}
+
+// stream writes the published events to the connection until the client
+// closes it or the context is done.
+func (s *Server) stream(ctx context.Context, conn net.Conn, encoder *json.Encoder) {
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	// Subscribers don't send anything else; reading detects they're gone
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) subscribe() chan Event {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+
+	events := make(chan Event, subscriberCapacity)
+	s.subscribers[events] = struct{}{}
+
+	return events
+}
+
+func (s *Server) unsubscribe(events chan Event) {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+
+	delete(s.subscribers, events)
+}
+
+// publish hands the event to every subscriber. If a subscriber isn't keeping
+// up with the events, the event is discarded for it.
+func (s *Server) publish(event Event) {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+
+	for events := range s.subscribers {
+		select {
+		case events <- event:
+		default:
+			s.logger.Warn("Discarding event: subscriber buffer full", slog.String("type", event.Type))
+		}
+	}
+}
+
+func (s *Server) OnPeerRegistered(peer prototari.Peer) {
+	s.publish(Event{Type: EventPeerRegistered, Peer: makePeer(peer)})
+}
+
+func (s *Server) OnPeerEvicted(peer prototari.Peer, reason prototari.EvictionReason) {
+	s.publish(Event{
+		Type:   EventPeerEvicted,
+		Peer:   makePeer(peer),
+		Reason: reason.String(),
+	})
+}
+
+func (s *Server) OnPeerStateChanged(change prototari.PeerStateChange) {
+	s.publish(Event{
+		Type: EventPeerState,
+		Peer: makePeer(change.Peer),
+		From: change.From,
+	})
+}
@@ -0,0 +1,146 @@
+package prototari
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A discoverySchedule decides the time between the discovery broadcasts.
+//
+// The first broadcasts, after starting and after being reset, are sent every
+// DiscoveryFastInterval, so that the computer is found quickly. The interval
+// then doubles with every broadcast up to the BroadcastInterval. Every interval
+// is shifted randomly by up to the DiscoveryJitter, so that the computers of a
+// network started at once don't broadcast in bursts. For the same reason, the
+// first broadcast waits a random time of up to the jitter of the first
+// interval.
+type discoverySchedule struct {
+	// sent is the number of broadcasts sent since the last reset.
+	sent int
+	// random returns a random number in [0, 1).
+	random func() float64
+}
+
+func makeDiscoverySchedule() discoverySchedule {
+	return discoverySchedule{random: rand.Float64}
+}
+
+// broadcastSent counts a broadcast.
+func (s *discoverySchedule) broadcastSent() {
+	s.sent++
+}
+
+// reset starts over with the fast broadcasts.
+func (s *discoverySchedule) reset() {
+	s.sent = 0
+}
+
+// interval returns the time to wait after the last broadcast, jitter included,
+// or before the first broadcast if none was sent since the last reset.
+func (s *discoverySchedule) interval(config Config) time.Duration {
+	var (
+		ceiling  = config.BroadcastInterval
+		interval = ceiling
+	)
+
+	if fast := config.DiscoveryFastInterval; fast > 0 && fast < ceiling {
+		interval = fast
+		for range s.sent - config.DiscoveryFastBroadcasts {
+			if interval *= 2; interval >= ceiling {
+				interval = ceiling
+				break
+			}
+		}
+	}
+
+	// Delayed by up to jitter × interval, to break the lockstep of the
+	// computers started, or reset, at once
+	if s.sent == 0 {
+		return time.Duration(config.DiscoveryJitter * s.random() * float64(interval))
+	}
+
+	// Shifted by up to ±jitter × interval
+	jitter := config.DiscoveryJitter * (2*s.random() - 1)
+	return interval + time.Duration(jitter*float64(interval))
+}
+
+// discoveryPayload returns the discovery message, advertising the number of
+// computers that can still be registered when it's below the
+// DiscoveryNearlyFull threshold. Otherwise, the responders would answer anyway,
+// so the plain message is sent, which the computers speaking older versions of
+// the protocol also answer. The broadcasters whose FullPolicy lets new
+// computers replace the peers don't advertise it either, as any of them may be
+// registered.
+func (m *CommsManager) discoveryPayload(config Config) []byte {
+	if config.FullPolicy != FullRefuse {
+		return []byte(discoveryMessage)
+	}
+
+	free := max(config.MaxPeers-m.NOfPeers(), 0)
+	if free >= config.DiscoveryNearlyFull {
+		return []byte(discoveryMessage)
+	}
+
+	return fmt.Appendf(nil, "%s %d", discoveryMessage, free)
+}
+
+// advertisedSlots returns the free slots advertised in a discovery message,
+// and whether there were any.
+func advertisedSlots(payload []byte) (int, bool) {
+	arg, ok := strings.CutPrefix(string(payload), discoveryMessage+" ")
+	if !ok {
+		return 0, false
+	}
+
+	free, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, false
+	}
+
+	return free, true
+}
+
+// answersBroadcast decides whether to answer a discovery message given the
+// free slots it advertises: never if there are none, and with a probability of
+// the free slots over nearlyFull if there are fewer than nearlyFull. The
+// messages that don't advertise them are always answered.
+func answersBroadcast(payload []byte, nearlyFull int) bool {
+	free, ok := advertisedSlots(payload)
+
+	switch {
+	case !ok:
+		return true
+	case free <= 0:
+		return false
+	case free >= nearlyFull:
+		return true
+	default:
+		return rand.IntN(nearlyFull) < free
+	}
+}
+
+// respondAfter answers the discovery message of a computer after the given
+// delay, if it should still be answered by then.
+func (m *CommsManager) respondAfter(IP net.IP, delay time.Duration) {
+	defer m.wg.Done()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-m.done:
+		return
+	}
+
+	// It may have been registered, or the room for it taken, in the meantime
+	if m.shouldRespond(IP) {
+		m.respond(IP)
+	} else {
+		m.forgetCandidate(IP)
+	}
+}
@@ -0,0 +1,284 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverySchedule(t *testing.T) {
+	var (
+		config = Config{
+			BroadcastInterval:       5 * time.Second,
+			DiscoveryFastInterval:   500 * time.Millisecond,
+			DiscoveryFastBroadcasts: 3,
+		}
+		// intervals returns the intervals after each of n broadcasts
+		intervals = func(schedule *discoverySchedule, config Config, n int) []time.Duration {
+			var got []time.Duration
+			for range n {
+				schedule.broadcastSent()
+				got = append(got, schedule.interval(config))
+			}
+			return got
+		}
+	)
+
+	t.Run("Fast broadcasts back off up to the broadcast interval", func(t *testing.T) {
+		schedule := makeDiscoverySchedule()
+
+		assert.Equal(
+			t,
+			[]time.Duration{
+				500 * time.Millisecond,
+				500 * time.Millisecond,
+				500 * time.Millisecond,
+				time.Second,
+				2 * time.Second,
+				4 * time.Second,
+				5 * time.Second,
+				5 * time.Second,
+			},
+			intervals(&schedule, config, 8),
+		)
+
+		schedule.reset()
+		assert.Equal(t, []time.Duration{500 * time.Millisecond}, intervals(&schedule, config, 1))
+	})
+
+	t.Run("Without fast broadcasts, every interval is the broadcast interval", func(t *testing.T) {
+		var (
+			schedule = makeDiscoverySchedule()
+			slow     = config
+		)
+		slow.DiscoveryFastInterval = 0
+
+		assert.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second}, intervals(&schedule, slow, 2))
+	})
+
+	t.Run("The intervals are shifted by up to the jitter", func(t *testing.T) {
+		jittery := config
+		jittery.DiscoveryJitter = 0.2
+
+		earliest := discoverySchedule{random: func() float64 { return 0 }}
+		assert.Equal(t, []time.Duration{400 * time.Millisecond}, intervals(&earliest, jittery, 1))
+
+		latest := discoverySchedule{random: func() float64 { return 0.75 }}
+		assert.Equal(t, []time.Duration{550 * time.Millisecond}, intervals(&latest, jittery, 1))
+
+		random := makeDiscoverySchedule()
+		for _, interval := range intervals(&random, jittery, 100) {
+			assert.GreaterOrEqual(t, interval, 400*time.Millisecond)
+			assert.LessOrEqual(t, interval, 6*time.Second)
+		}
+	})
+
+	t.Run("The first broadcast is delayed by up to the jitter", func(t *testing.T) {
+		jittery := config
+		jittery.DiscoveryJitter = 0.2
+
+		latest := discoverySchedule{random: func() float64 { return 0.5 }}
+		assert.Equal(t, 50*time.Millisecond, latest.interval(jittery))
+
+		latest.broadcastSent()
+		latest.reset()
+		assert.Equal(t, 50*time.Millisecond, latest.interval(jittery))
+
+		random := makeDiscoverySchedule()
+		for range 100 {
+			delay := random.interval(jittery)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.Less(t, delay, 100*time.Millisecond)
+		}
+
+		// Without jitter, it's sent right away
+		assert.Equal(t, time.Duration(0), random.interval(config))
+	})
+
+	t.Run("Computers starting broadcast quickly", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: BroadcastPort}
+			written   = make(chan fakeMsgRecord, 16)
+			broadConn = fakeBroadcastConn{
+				localAddr: localAddr,
+				writeChan: make(chan fakeMsgRecord, 16),
+				written:   written,
+			}
+			config = makeTestingConfig()
+		)
+		config.DiscoveryFastInterval = 100 * time.Millisecond
+		config.DiscoveryFastBroadcasts = 2
+
+		manager := MakeManager(&broadConn, &fakeUnicastConn{localAddr: localAddr}, config)
+		manager.Start()
+		defer manager.Stop()
+
+		// The broadcast interval is 10 minutes
+		timeout := time.After(2 * time.Second)
+		for range 4 {
+			select {
+			case <-written:
+			case <-timeout:
+				assert.FailNow(t, "The broadcasts weren't sent quickly")
+			}
+		}
+	})
+
+	t.Run("Computers losing every peer broadcast right away", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: BroadcastPort}
+			peerIP    = net.ParseIP("192.168.0.20")
+			written   = make(chan fakeMsgRecord, 16)
+			broadConn = fakeBroadcastConn{
+				localAddr: localAddr,
+				writeChan: make(chan fakeMsgRecord, 16),
+				written:   written,
+			}
+			config = makeTestingConfig()
+		)
+		config.MaxPeers = 2
+
+		manager := MakeManager(&broadConn, &fakeUnicastConn{localAddr: localAddr}, config)
+		manager.registerPeer(MakePeer(peerIP))
+		manager.Start()
+		defer manager.Stop()
+
+		// The first broadcast is sent when starting
+		<-written
+		assert.Nil(t, manager.Disconnect(peerIP))
+
+		select {
+		case <-written:
+		case <-time.After(time.Second):
+			assert.FailNow(t, "No broadcast was sent")
+		}
+	})
+}
+
+func TestDiscoveryResponses(t *testing.T) {
+	var (
+		localAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: BroadcastPort}
+		peerAddr    = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: BroadcastPort}
+		makeManager = func(config Config) (*CommsManager, chan<- fakeMsgRecord, <-chan fakeMsgRecord) {
+			var (
+				readCh   = make(chan fakeMsgRecord)
+				writeCh  = make(chan fakeMsgRecord, 16)
+				unicConn = fakeUnicastConn{
+					localAddr: localAddr,
+					writeChan: writeCh,
+					written:   make(chan fakeMsgRecord, 16),
+				}
+				broadConn = fakeBroadcastConn{localAddr: localAddr, readChan: readCh}
+			)
+
+			return MakeManager(&broadConn, &unicConn, config), readCh, writeCh
+		}
+	)
+
+	t.Run("Nearly full broadcasters refusing new computers advertise their free slots", func(t *testing.T) {
+		config := makeTestingConfig()
+		config.MaxPeers = 3
+		config.DiscoveryNearlyFull = 4
+		manager, _, _ := makeManager(config)
+		manager.registerPeer(MakePeer(peerAddr.IP))
+
+		assert.Equal(t, "pelotari? 2", string(manager.discoveryPayload(config)))
+
+		config.DiscoveryNearlyFull = 2
+		assert.Equal(t, discoveryMessage, string(manager.discoveryPayload(config)))
+
+		config.DiscoveryNearlyFull = 4
+		config.FullPolicy = FullEvictLeastRecentlySeen
+		assert.Equal(t, discoveryMessage, string(manager.discoveryPayload(config)))
+	})
+
+	t.Run("Nearly full broadcasters are answered less often", func(t *testing.T) {
+		assert.True(t, answersBroadcast([]byte(discoveryMessage), 4))
+		assert.False(t, answersBroadcast([]byte("pelotari? 0"), 4))
+		assert.True(t, answersBroadcast([]byte("pelotari? 4"), 4))
+		assert.False(t, answersBroadcast([]byte("pelotari? 0"), 0))
+
+		answered := 0
+		for range 1000 {
+			if answersBroadcast([]byte("pelotari? 1"), 4) {
+				answered++
+			}
+		}
+		assert.InDelta(t, 250, answered, 100)
+	})
+
+	t.Run("Full broadcasters aren't answered", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager(makeTestingConfig())
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte("pelotari? 0")}
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+
+		select {
+		case msg := <-writeCh:
+			assert.Equal(t, responseMessage, string(msg.Payload))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "The broadcast wasn't answered")
+		}
+		assert.Empty(t, writeCh)
+	})
+
+	t.Run("Responses are sent after a random delay, once", func(t *testing.T) {
+		config := makeTestingConfig()
+		config.DiscoveryResponseDelay = 200 * time.Millisecond
+		manager, readCh, writeCh := makeManager(config)
+		manager.Start()
+		defer manager.Stop()
+
+		received := time.Now()
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+
+		select {
+		case msg := <-writeCh:
+			assert.Equal(t, responseMessage, string(msg.Payload))
+			assert.Less(t, time.Since(received), config.DiscoveryResponseDelay+100*time.Millisecond)
+		case <-time.After(time.Second):
+			assert.FailNow(t, "The broadcast wasn't answered")
+		}
+
+		select {
+		case msg := <-writeCh:
+			assert.FailNow(t, "The broadcast was answered twice", string(msg.Payload))
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+
+	t.Run("Broadcasters that can't be tracked aren't answered", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager(makeTestingConfig())
+		for i := range maxCandidates {
+			manager.advanceCandidate(net.IPv4(10, 0, byte(i>>8), byte(i)), PeerDiscovered)
+		}
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+
+		select {
+		case msg := <-writeCh:
+			assert.FailNow(t, "The broadcast was answered", string(msg.Payload))
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("Pending responses are dropped when stopping", func(t *testing.T) {
+		config := makeTestingConfig()
+		config.DiscoveryResponseDelay = time.Hour
+		manager, readCh, writeCh := makeManager(config)
+		manager.Start()
+
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+
+		assert.Nil(t, manager.Stop())
+		assert.Empty(t, writeCh)
+	})
+}
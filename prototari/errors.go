@@ -0,0 +1,105 @@
+package prototari
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// errorsChCapacity is the number of errors the CommsManager buffers for the
+// application before it starts discarding new ones.
+const errorsChCapacity = 16
+
+// ErrMaxPeers is the reason a peer isn't registered when the maximum number
+// of peers are already registered.
+var ErrMaxPeers = errors.New("max peers registered")
+
+// ErrNotAdmitted is the reason a peer isn't registered when the configured
+// admission filter rejects it.
+var ErrNotAdmitted = errors.New("peer not admitted")
+
+// ErrRebindRequired is returned when updating the configuration with changes
+// that require opening the connections again, like changing the ports.
+var ErrRebindRequired = errors.New("change requires rebinding the connections")
+
+// ErrNoReply is returned when a peer doesn't answer a ping within the
+// heartbeat max wait time.
+var ErrNoReply = errors.New("no reply")
+
+// A HandshakeError is reported when a handshake with another computer fails.
+type HandshakeError struct {
+	// Peer is the computer the handshake was with.
+	Peer Peer
+	// Role is the part this computer played in the handshake.
+	Role HandshakeRole
+	// Err is the cause, like ErrMaxPeers.
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake with %s as %s: %s", e.Peer.IP, e.Role, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// A SendError is reported when a message can't be sent.
+type SendError struct {
+	// Addr is the destination of the message, or nil for the discovery
+	// broadcasts.
+	Addr *net.UDPAddr
+	// Err is the cause.
+	Err error
+}
+
+func (e *SendError) Error() string {
+	if e.Addr == nil {
+		return fmt.Sprintf("sending broadcast: %s", e.Err)
+	}
+
+	return fmt.Sprintf("sending to %s: %s", e.Addr, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// A ReadError is reported when reading from a connection fails.
+type ReadError struct {
+	// Conn is the name of the connection: "broadcast" or "unicast".
+	Conn string
+	// Fatal is whether the connection can't be read from anymore, in which
+	// case the communications can't go on.
+	Fatal bool
+	// Err is the cause.
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("reading from %s connection: %s", e.Conn, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns a channel of the errors happening in the CommsManager's
+// goroutines: *HandshakeError, *SendError and *ReadError.
+// The channel is buffered; if the application doesn't keep up with the errors,
+// the new ones are discarded.
+func (m *CommsManager) Errors() <-chan error {
+	return m.errorsCh
+}
+
+// reportErr hands an error to the observer and the application.
+func (m *CommsManager) reportErr(err error) {
+	m.observer.OnError(err)
+
+	select {
+	case m.errorsCh <- err:
+	default:
+		m.logger.Debug("Discarding error: errors buffer full", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,75 @@
+package prototari
+
+import (
+	"fmt"
+	"slices"
+)
+
+// An EvictionPolicy decides which peers are evicted when there are more
+// registered peers than allowed, like when MaxPeers is reduced.
+//
+// It's unrelated to the FullPolicy, which decides whether a new computer
+// replaces a peer when MaxPeers are registered: the EvictionPolicy only
+// chooses the peers dropped when no computer is taking their place.
+type EvictionPolicy int
+
+const (
+	// EvictLeastRecentlySeen evicts the peers heard from the longest ago.
+	EvictLeastRecentlySeen EvictionPolicy = iota
+	// EvictNewest evicts the most recently registered peers, keeping the
+	// long-standing ones.
+	EvictNewest
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLeastRecentlySeen:
+		return "least-recently-seen"
+	case EvictNewest:
+		return "newest"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the policy as its name, like "least-recently-seen".
+func (p EvictionPolicy) MarshalText() ([]byte, error) {
+	if p < EvictLeastRecentlySeen || p > EvictNewest {
+		return nil, fmt.Errorf("unknown eviction policy %d", int(p))
+	}
+
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy from its name, like "least-recently-seen".
+func (p *EvictionPolicy) UnmarshalText(text []byte) error {
+	for policy := EvictLeastRecentlySeen; policy <= EvictNewest; policy++ {
+		if string(text) == policy.String() {
+			*p = policy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown eviction policy %q", text)
+}
+
+// victims returns the n peers the policy evicts first.
+func (p EvictionPolicy) victims(peers []Peer, n int) []Peer {
+	if n <= 0 {
+		return nil
+	}
+
+	peers = slices.Clone(peers)
+	switch p {
+	case EvictNewest:
+		slices.SortFunc(peers, func(a, b Peer) int {
+			return b.Registered.Compare(a.Registered)
+		})
+	default:
+		slices.SortFunc(peers, func(a, b Peer) int {
+			return a.LastSeen.Compare(b.LastSeen)
+		})
+	}
+
+	return peers[:min(n, len(peers))]
+}
@@ -0,0 +1,323 @@
+package prototari
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// A FailureDetector decides whether the registered peers are up from the times
+// their messages arrive and the heartbeats they don't answer.
+//
+// The CommsManager tells it about every message received from a registered
+// peer and every missed heartbeat, and asks for its verdict on every peer
+// periodically: connected, suspect, or disconnected, in which case the peer is
+// evicted. Its methods are called from several goroutines, so it must be safe
+// for concurrent use, and they mustn't call the CommsManager.
+type FailureDetector interface {
+	// Heard records that a message from the peer arrived at the given time.
+	Heard(IP net.IP, at time.Time)
+	// Missed records that the peer didn't answer a heartbeat in time.
+	Missed(IP net.IP, at time.Time)
+	// Verdict returns whether the peer is PeerConnected, PeerSuspect or
+	// PeerDisconnected at the given time. Peers it knows nothing about are
+	// connected.
+	Verdict(IP net.IP, now time.Time) PeerState
+	// Forget discards what's known about the peer, which was unregistered.
+	Forget(IP net.IP)
+}
+
+// FailureDetectorNamed returns the failure detector with the given name, with
+// its default parameters, to select one in a configuration file:
+// "phi-accrual", or "threshold", which is the nil detector, counting up to the
+// configured MaxMissedHeartbeats.
+func FailureDetectorNamed(name string) (FailureDetector, error) {
+	switch name {
+	case "threshold":
+		return nil, nil
+	case "phi-accrual":
+		return MakePhiAccrualDetector(MakeDefaultPhiAccrualParams()), nil
+	default:
+		return nil, fmt.Errorf("unknown failure detector %q", name)
+	}
+}
+
+// A ThresholdDetector suspects the peers that missed a heartbeat, and declares
+// disconnected those that missed a fixed number of heartbeats in a row.
+// It's the failure detector used when none is configured, with the configured
+// MaxMissedHeartbeats.
+type ThresholdDetector struct {
+	mutex     sync.Mutex
+	maxMissed int
+	missed    map[string]int
+}
+
+// MakeThresholdDetector returns a detector declaring disconnected the peers
+// that miss maxMissed heartbeats in a row.
+func MakeThresholdDetector(maxMissed int) *ThresholdDetector {
+	return &ThresholdDetector{
+		maxMissed: maxMissed,
+		missed:    make(map[string]int),
+	}
+}
+
+// setMaxMissed changes the number of heartbeats in a row a peer can miss.
+func (d *ThresholdDetector) setMaxMissed(maxMissed int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.maxMissed = maxMissed
+}
+
+func (d *ThresholdDetector) Heard(IP net.IP, at time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.missed, peerKey(IP))
+}
+
+func (d *ThresholdDetector) Missed(IP net.IP, at time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.missed[peerKey(IP)]++
+}
+
+func (d *ThresholdDetector) Verdict(IP net.IP, now time.Time) PeerState {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch missed := d.missed[peerKey(IP)]; {
+	case missed >= d.maxMissed:
+		return PeerDisconnected
+	case missed > 0:
+		return PeerSuspect
+	default:
+		return PeerConnected
+	}
+}
+
+func (d *ThresholdDetector) Forget(IP net.IP) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.missed, peerKey(IP))
+}
+
+func (d *ThresholdDetector) String() string {
+	return "threshold"
+}
+
+// PhiAccrualParams are the parameters of a PhiAccrualDetector.
+type PhiAccrualParams struct {
+	// SuspectPhi is the suspicion level from which peers are suspect.
+	SuspectPhi float64
+	// DisconnectPhi is the suspicion level from which peers are disconnected.
+	DisconnectPhi float64
+	// WindowSize is the number of intervals between messages kept per peer.
+	WindowSize int
+	// MinInterval is the shortest interval between messages sampled. The
+	// messages arriving sooner after the previous one only count as the
+	// latest arrival, so that bursts of messages don't make the silences
+	// between them look suspicious.
+	MinInterval time.Duration
+	// MinStdDev is the lowest standard deviation of the intervals considered,
+	// so that very regular peers aren't declared disconnected for a small
+	// delay.
+	MinStdDev time.Duration
+	// AcceptablePause is a delay added to the mean interval, tolerated before
+	// the suspicion starts growing.
+	AcceptablePause time.Duration
+	// FirstInterval is the interval expected before any is observed.
+	FirstInterval time.Duration
+}
+
+// MakeDefaultPhiAccrualParams returns parameters suited to the default
+// heartbeat times.
+func MakeDefaultPhiAccrualParams() PhiAccrualParams {
+	return PhiAccrualParams{
+		SuspectPhi:      3,
+		DisconnectPhi:   8,
+		WindowSize:      100,
+		MinInterval:     time.Second,
+		MinStdDev:       500 * time.Millisecond,
+		AcceptablePause: 0,
+		FirstInterval:   defaultInactivePeerTime,
+	}
+}
+
+// A PhiAccrualDetector adapts to the intervals observed between the messages
+// of each peer. Instead of a yes or no answer, it computes the suspicion level
+// phi for the time since the last message: how unlikely it is to go that long
+// without hearing from the peer, given its past intervals. A phi of 1 means a
+// 10% chance that a message still arrives, 2 a 1% chance, 3 a 0.1% chance and
+// so on. The peers on lossy links, whose intervals vary, are given more time
+// than those on steady ones.
+//
+// The missed heartbeats are taken into account through the time without
+// hearing from the peer, so Missed does nothing.
+type PhiAccrualDetector struct {
+	params PhiAccrualParams
+
+	mutex    sync.Mutex
+	arrivals map[string]*arrivalWindow
+}
+
+// MakePhiAccrualDetector returns a phi accrual detector with the given
+// parameters.
+func MakePhiAccrualDetector(params PhiAccrualParams) *PhiAccrualDetector {
+	return &PhiAccrualDetector{
+		params:   params,
+		arrivals: make(map[string]*arrivalWindow),
+	}
+}
+
+// setParams changes the parameters, keeping the intervals observed.
+func (d *PhiAccrualDetector) setParams(params PhiAccrualParams) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.params = params
+}
+
+// An arrivalWindow holds the latest intervals between the messages of a peer.
+type arrivalWindow struct {
+	last      time.Time
+	intervals []time.Duration
+	next      int
+	// estimated is whether the intervals are the estimation made before
+	// observing any.
+	estimated bool
+}
+
+func (w *arrivalWindow) add(interval time.Duration, size int) {
+	if w.estimated {
+		w.intervals, w.estimated = w.intervals[:0], false
+	}
+
+	if len(w.intervals) > size {
+		// The window was shrunk
+		w.intervals, w.next = w.intervals[len(w.intervals)-size:], 0
+	}
+
+	if len(w.intervals) < size {
+		w.intervals = append(w.intervals, interval)
+		return
+	}
+
+	w.intervals[w.next] = interval
+	w.next = (w.next + 1) % size
+}
+
+// stats returns the mean and standard deviation of the intervals.
+func (w *arrivalWindow) stats() (mean, stdDev float64) {
+	for _, interval := range w.intervals {
+		mean += float64(interval)
+	}
+	mean /= float64(len(w.intervals))
+
+	for _, interval := range w.intervals {
+		diff := float64(interval) - mean
+		stdDev += diff * diff
+	}
+	stdDev = math.Sqrt(stdDev / float64(len(w.intervals)))
+
+	return mean, stdDev
+}
+
+func (d *PhiAccrualDetector) Heard(IP net.IP, at time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := peerKey(IP)
+	window, ok := d.arrivals[key]
+	if !ok {
+		// Until some are observed, the intervals are expected to be around
+		// the first interval
+		var (
+			first     = d.params.FirstInterval
+			deviation = first / 4
+		)
+		window = &arrivalWindow{
+			intervals: []time.Duration{first - deviation, first + deviation},
+			estimated: true,
+		}
+		d.arrivals[key] = window
+	} else if interval := at.Sub(window.last); interval >= d.params.MinInterval {
+		window.add(interval, d.params.WindowSize)
+	}
+
+	if at.After(window.last) {
+		window.last = at
+	}
+}
+
+func (d *PhiAccrualDetector) Missed(IP net.IP, at time.Time) {}
+
+func (d *PhiAccrualDetector) Verdict(IP net.IP, now time.Time) PeerState {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch phi := d.suspicion(IP, now); {
+	case phi >= d.params.DisconnectPhi:
+		return PeerDisconnected
+	case phi >= d.params.SuspectPhi:
+		return PeerSuspect
+	default:
+		return PeerConnected
+	}
+}
+
+// Phi returns the suspicion level that the peer is down at the given time, or
+// zero if nothing was heard from it.
+func (d *PhiAccrualDetector) Phi(IP net.IP, now time.Time) float64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.suspicion(IP, now)
+}
+
+// suspicion returns the suspicion level of the peer. It must be called with
+// the mutex held.
+func (d *PhiAccrualDetector) suspicion(IP net.IP, now time.Time) float64 {
+	window, ok := d.arrivals[peerKey(IP)]
+	if !ok {
+		return 0
+	}
+
+	mean, stdDev := window.stats()
+	mean += float64(d.params.AcceptablePause)
+	stdDev = max(stdDev, float64(d.params.MinStdDev))
+
+	return phi(float64(now.Sub(window.last)), mean, stdDev)
+}
+
+// phi returns -log10 of the probability of an interval being longer than the
+// elapsed time, with the intervals following a normal distribution. The
+// distribution's cumulative function is approximated with a logistic
+// function.
+func phi(elapsed, mean, stdDev float64) float64 {
+	var (
+		y = (elapsed - mean) / stdDev
+		e = math.Exp(-y * (1.5976 + 0.070566*y*y))
+	)
+
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+
+	return -math.Log10(1 - 1/(1+e))
+}
+
+func (d *PhiAccrualDetector) Forget(IP net.IP) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.arrivals, peerKey(IP))
+}
+
+func (d *PhiAccrualDetector) String() string {
+	return "phi-accrual"
+}
@@ -0,0 +1,192 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThresholdDetector(t *testing.T) {
+	var (
+		IP  = net.ParseIP("192.168.0.20")
+		now = time.Now()
+	)
+
+	t.Run("Peers are suspect after a miss and disconnected after the maximum", func(t *testing.T) {
+		detector := MakeThresholdDetector(2)
+		detector.Heard(IP, now)
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, now))
+
+		detector.Missed(IP, now)
+		assert.Equal(t, PeerSuspect, detector.Verdict(IP, now))
+
+		detector.Missed(IP, now)
+		assert.Equal(t, PeerDisconnected, detector.Verdict(IP, now))
+	})
+
+	t.Run("Hearing from a peer resets its misses", func(t *testing.T) {
+		detector := MakeThresholdDetector(2)
+		detector.Missed(IP, now)
+		detector.Heard(IP, now)
+
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, now))
+	})
+}
+
+func TestPhiAccrualDetector(t *testing.T) {
+	var (
+		IP     = net.ParseIP("192.168.0.20")
+		start  = time.Now()
+		params = MakeDefaultPhiAccrualParams()
+		// heardEvery feeds the detector a message every interval, with a
+		// small variation, returning the time of the last one
+		heardEvery = func(detector *PhiAccrualDetector, interval time.Duration) time.Time {
+			at := start
+			for i := range 50 {
+				at = at.Add(interval + time.Duration(i%3)*100*time.Millisecond)
+				detector.Heard(IP, at)
+			}
+			return at
+		}
+	)
+
+	t.Run("Suspicion grows with the silence", func(t *testing.T) {
+		var (
+			detector = MakePhiAccrualDetector(params)
+			last     = heardEvery(detector, 2*time.Second)
+		)
+
+		assert.Less(t, detector.Phi(IP, last.Add(2*time.Second)), 1.0)
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, last.Add(2*time.Second)))
+		assert.Equal(t, PeerSuspect, detector.Verdict(IP, last.Add(4*time.Second)))
+		assert.Equal(t, PeerDisconnected, detector.Verdict(IP, last.Add(10*time.Second)))
+	})
+
+	t.Run("Peers with longer intervals are given more time", func(t *testing.T) {
+		var (
+			steady = MakePhiAccrualDetector(params)
+			slow   = MakePhiAccrualDetector(params)
+		)
+		steadyLast := heardEvery(steady, 2*time.Second)
+		slowLast := heardEvery(slow, 10*time.Second)
+
+		assert.Equal(t, PeerDisconnected, steady.Verdict(IP, steadyLast.Add(8*time.Second)))
+		assert.Equal(t, PeerConnected, slow.Verdict(IP, slowLast.Add(8*time.Second)))
+	})
+
+	t.Run("Bursts of messages don't shorten the expected interval", func(t *testing.T) {
+		detector := MakePhiAccrualDetector(params)
+		last := heardEvery(detector, 10*time.Second)
+
+		for i := range 100 {
+			detector.Heard(IP, last.Add(time.Duration(i)*time.Millisecond))
+		}
+		last = last.Add(99 * time.Millisecond)
+
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, last.Add(10*time.Second)))
+	})
+
+	t.Run("Unknown and forgotten peers are connected", func(t *testing.T) {
+		detector := MakePhiAccrualDetector(params)
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, start))
+
+		last := heardEvery(detector, time.Second)
+		detector.Forget(IP)
+		assert.Equal(t, PeerConnected, detector.Verdict(IP, last.Add(time.Hour)))
+	})
+
+	t.Run("The CommsManager evicts the peers the detector declares disconnected", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			config    = makeTestingConfig()
+			detector  = MakePhiAccrualDetector(params)
+		)
+		config.FailureDetector = detector
+
+		var (
+			manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &fakeUnicastConn{localAddr: localAddr}, config)
+			peer    = MakePeer(IP)
+		)
+		manager.registerPeer(peer)
+
+		manager.checkHeartbeats(peer.LastSeen.Add(params.FirstInterval))
+		assert.True(t, manager.hasPeer(IP))
+
+		manager.checkHeartbeats(peer.LastSeen.Add(4 * params.FirstInterval))
+		assert.False(t, manager.hasPeer(IP))
+	})
+
+	t.Run("Peers the detector stops suspecting are connected again", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			observer  stateChangesObserver
+			config    = makeTestingConfig()
+		)
+		config.FailureDetector = MakePhiAccrualDetector(params)
+		config.Observer = &observer
+
+		var (
+			manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &fakeUnicastConn{localAddr: localAddr}, config)
+			peer    = MakePeer(IP)
+			silence = peer.LastSeen.Add(2 * params.FirstInterval)
+		)
+		manager.registerPeer(peer)
+
+		manager.checkHeartbeats(silence)
+		registered, _ := manager.Peer(IP)
+		assert.Equal(t, PeerSuspect, registered.State)
+
+		// Nothing is heard from the peer, but the detector is more tolerant
+		tolerant := params
+		tolerant.SuspectPhi = 6
+		config.FailureDetector = MakePhiAccrualDetector(tolerant)
+		assert.Nil(t, manager.UpdateConfig(config))
+
+		manager.checkHeartbeats(silence)
+		registered, _ = manager.Peer(IP)
+		assert.Equal(t, PeerConnected, registered.State)
+		assert.Equal(t, []string{
+			"disconnected -> connected",
+			"connected -> suspect",
+			"suspect -> connected",
+		}, observer.recorded())
+	})
+
+	t.Run("A detector configured on the fly judges the silent peers", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			config    = makeTestingConfig()
+			manager   = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &fakeUnicastConn{localAddr: localAddr}, config)
+			peer      = MakePeer(IP)
+		)
+		manager.registerPeer(peer)
+
+		config.FailureDetector = MakePhiAccrualDetector(params)
+		assert.Nil(t, manager.UpdateConfig(config))
+
+		manager.checkHeartbeats(peer.LastSeen.Add(4 * params.FirstInterval))
+		assert.False(t, manager.hasPeer(IP))
+	})
+
+	t.Run("Configuring a phi accrual detector again keeps the intervals observed", func(t *testing.T) {
+		var (
+			localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+			config    = makeTestingConfig()
+			detector  = MakePhiAccrualDetector(params)
+		)
+		config.FailureDetector = detector
+
+		manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &fakeUnicastConn{localAddr: localAddr}, config)
+		last := heardEvery(detector, time.Second)
+
+		newParams := params
+		newParams.DisconnectPhi = 20
+		config.FailureDetector = MakePhiAccrualDetector(newParams)
+		assert.Nil(t, manager.UpdateConfig(config))
+
+		assert.Same(t, detector, manager.Config().FailureDetector)
+		assert.Equal(t, PeerSuspect, detector.Verdict(IP, last.Add(4*time.Second)))
+	})
+}
@@ -0,0 +1,264 @@
+package prototari
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minReplacedLoss is the lowest recent loss of the link to a peer for the
+// FullEvictWorstLink policy to replace it.
+const minReplacedLoss = 0.25
+
+// A FullPolicy decides what happens when a computer wants to be registered as
+// peer and MaxPeers are already registered.
+type FullPolicy int
+
+const (
+	// FullRefuse refuses the new computer, keeping the registered peers.
+	FullRefuse FullPolicy = iota
+	// FullEvictLeastRecentlySeen evicts the peer heard from the longest ago
+	// to make room for the new computer, if it wasn't heard from for
+	// InactivePeerTime, like the peers not answering the heartbeats.
+	// Otherwise, the new computer is refused.
+	FullEvictLeastRecentlySeen
+	// FullEvictWorstLink evicts the peer with the worst link, the one losing
+	// the most probes, or the slowest if they lose as many, if its link lost
+	// a good share of the recent probes. Otherwise, the new computer, whose
+	// link isn't known yet, is refused.
+	FullEvictWorstLink
+	// FullEvictLowestPriority evicts the peer with the lowest priority, as
+	// given by Config.Priority, if it's lower than the new computer's.
+	// Otherwise, the new computer is refused.
+	FullEvictLowestPriority
+)
+
+func (p FullPolicy) String() string {
+	switch p {
+	case FullRefuse:
+		return "refuse"
+	case FullEvictLeastRecentlySeen:
+		return "evict-least-recently-seen"
+	case FullEvictWorstLink:
+		return "evict-worst-link"
+	case FullEvictLowestPriority:
+		return "evict-lowest-priority"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the policy as its name, like "refuse".
+func (p FullPolicy) MarshalText() ([]byte, error) {
+	if p < FullRefuse || p > FullEvictLowestPriority {
+		return nil, fmt.Errorf("unknown full policy %d", int(p))
+	}
+
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy from its name, like "refuse".
+func (p *FullPolicy) UnmarshalText(text []byte) error {
+	for policy := FullRefuse; policy <= FullEvictLowestPriority; policy++ {
+		if string(text) == policy.String() {
+			*p = policy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown full policy %q", text)
+}
+
+// An IPList is a list of IPs, written separated by commas in the configuration
+// files and flags.
+type IPList []net.IP
+
+// contains returns whether the IP is in the list.
+func (l IPList) contains(IP net.IP) bool {
+	return slices.ContainsFunc(l, IP.Equal)
+}
+
+// MarshalText encodes the IPs separated by commas.
+func (l IPList) MarshalText() ([]byte, error) {
+	ips := make([]string, len(l))
+	for i, IP := range l {
+		ips[i] = IP.String()
+	}
+
+	return []byte(strings.Join(ips, ",")), nil
+}
+
+// UnmarshalText decodes IPs separated by commas, like
+// "192.168.0.1,192.168.0.2".
+func (l *IPList) UnmarshalText(text []byte) error {
+	var ips IPList
+	for _, field := range strings.Split(string(text), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		IP := net.ParseIP(field)
+		if IP == nil {
+			return fmt.Errorf("invalid IP %q", field)
+		}
+		ips = append(ips, IP)
+	}
+
+	*l = ips
+	return nil
+}
+
+// pinned checks whether the IP is one of the pinned peers.
+func (c Config) pinned(IP net.IP) bool {
+	return c.PinnedPeers.contains(IP)
+}
+
+// priority returns the priority of the IP, or zero if there's no Priority
+// function.
+func (c Config) priority(IP net.IP) int {
+	if c.Priority == nil {
+		return 0
+	}
+
+	return c.Priority(IP)
+}
+
+// unpinnedPeers returns the registered peers that aren't pinned.
+//
+// The caller must hold the peers mutex.
+func (m *CommsManager) unpinnedPeers(config Config) []Peer {
+	var unpinned []Peer
+	for _, peer := range m.peers {
+		if !config.pinned(peer.IP) {
+			unpinned = append(unpinned, peer)
+		}
+	}
+
+	return unpinned
+}
+
+// makeRoom decides whether a computer can be registered as peer, and which
+// registered peer it replaces, if any.
+//
+// The pinned peers have reserved slots: they can always be registered, and
+// are never replaced. The rest can take the slots left, up to MaxPeers minus
+// the number of pinned peers. When there isn't a free slot for the computer, a
+// peer that isn't pinned is chosen to be replaced following the FullPolicy,
+// if the policy deems the computer better than it; if there's none, it
+// returns ErrMaxPeers.
+//
+// The caller must hold the peers mutex.
+func (m *CommsManager) makeRoom(IP net.IP, config Config) (*Peer, error) {
+	var (
+		isPinned  = config.pinned(IP)
+		unpinned  = m.unpinnedPeers(config)
+		available = config.MaxPeers - len(m.peers)
+	)
+	if !isPinned {
+		available = min(available, config.MaxPeers-len(config.PinnedPeers)-len(unpinned))
+	}
+
+	if available > 0 {
+		return nil, nil
+	}
+	if len(unpinned) == 0 {
+		return nil, ErrMaxPeers
+	}
+
+	// The slots reserved for the pinned peers are freed whatever the policy
+	policy := config.FullPolicy
+	if isPinned {
+		policy = FullEvictLeastRecentlySeen
+	} else if policy == FullRefuse {
+		return nil, ErrMaxPeers
+	}
+
+	victim := policy.victim(unpinned, config)
+	if !isPinned && !policy.replaceable(victim, config, time.Now()) {
+		return nil, ErrMaxPeers
+	}
+	if policy == FullEvictLowestPriority && config.priority(victim.IP) >= config.priority(IP) {
+		return nil, ErrMaxPeers
+	}
+
+	return &victim, nil
+}
+
+// replaceable returns whether the policy lets any new computer replace the
+// peer, so that a full computer doesn't swap healthy peers for new ones over
+// and over: the peers it hasn't heard from for InactivePeerTime, or whose link
+// loses at least minReplacedLoss of the probes, depending on the policy. The
+// new computer was just heard from and its link isn't known yet, so it's only
+// better than those. Under FullEvictLowestPriority, it depends on the new
+// computer's priority instead.
+func (p FullPolicy) replaceable(peer Peer, config Config, now time.Time) bool {
+	switch p {
+	case FullEvictLeastRecentlySeen:
+		return now.Sub(peer.LastSeen) >= config.InactivePeerTime
+	case FullEvictWorstLink:
+		return peer.Link.Loss >= minReplacedLoss
+	default:
+		return true
+	}
+}
+
+// victim returns the peer the policy replaces among the given ones.
+func (p FullPolicy) victim(peers []Peer, config Config) Peer {
+	switch p {
+	case FullEvictWorstLink:
+		return slices.MaxFunc(peers, func(a, b Peer) int {
+			return cmp.Or(
+				cmp.Compare(a.Link.Loss, b.Link.Loss),
+				cmp.Compare(a.Link.RTT, b.Link.RTT),
+				b.LastSeen.Compare(a.LastSeen),
+			)
+		})
+	case FullEvictLowestPriority:
+		return slices.MinFunc(peers, func(a, b Peer) int {
+			return cmp.Or(
+				cmp.Compare(config.priority(a.IP), config.priority(b.IP)),
+				a.LastSeen.Compare(b.LastSeen),
+			)
+		})
+	default:
+		return slices.MinFunc(peers, func(a, b Peer) int {
+			return a.LastSeen.Compare(b.LastSeen)
+		})
+	}
+}
+
+// hasRoomFor returns whether a computer could be registered as peer now.
+func (m *CommsManager) hasRoomFor(IP net.IP, config Config) bool {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	_, err := m.makeRoom(IP, config)
+	return err == nil
+}
+
+// seekingPeers returns whether new computers may be registered as peers, so
+// that the discovery goes on: if there's room for them, or one of the
+// registered peers may be replaced now.
+//
+// Under FullEvictLowestPriority, whether a peer is replaced depends on the
+// priority of the computers answering, which isn't known, so a full computer
+// stops broadcasting; the computers with a higher priority still replace its
+// peers when it answers their broadcasts.
+func (m *CommsManager) seekingPeers(config Config) bool {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	if len(m.peers) < config.MaxPeers {
+		return true
+	}
+	if config.FullPolicy == FullRefuse || config.FullPolicy == FullEvictLowestPriority {
+		return false
+	}
+
+	unpinned := m.unpinnedPeers(config)
+	return len(unpinned) > 0 &&
+		config.FullPolicy.replaceable(config.FullPolicy.victim(unpinned, config), config, time.Now())
+}
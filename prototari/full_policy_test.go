@@ -0,0 +1,184 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// evictionsObserver records the evicted peers and the reasons.
+type evictionsObserver struct {
+	NoopObserver
+	evicted map[string]EvictionReason
+}
+
+func (o *evictionsObserver) OnPeerEvicted(peer Peer, reason EvictionReason) {
+	o.evicted[peer.IP.String()] = reason
+}
+
+func TestFullPolicy(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		ips       = []net.IP{
+			net.ParseIP("192.168.0.20"),
+			net.ParseIP("192.168.0.21"),
+		}
+		newIP    = net.ParseIP("192.168.0.30")
+		pinnedIP = net.ParseIP("192.168.0.1")
+		// makeFullManager returns a manager with the peers registered, the
+		// first one the least recently seen
+		makeFullManager = func(configure func(*Config)) (*CommsManager, *evictionsObserver) {
+			var (
+				observer = &evictionsObserver{evicted: make(map[string]EvictionReason)}
+				config   = makeTestingConfig()
+			)
+			config.MaxPeers = len(ips)
+			config.Observer = observer
+			configure(&config)
+
+			var (
+				manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &fakeUnicastConn{localAddr: localAddr}, config)
+				now     = time.Now()
+			)
+			for i, IP := range ips {
+				peer := MakePeer(IP)
+				peer.LastSeen = now.Add(time.Duration(i) * time.Second)
+				manager.registerPeer(peer)
+			}
+
+			return manager, observer
+		}
+		// updatePeer changes a registered peer
+		updatePeer = func(manager *CommsManager, IP net.IP, update func(*Peer)) {
+			manager.peersMutex.Lock()
+			defer manager.peersMutex.Unlock()
+
+			peer := manager.peers[peerKey(IP)]
+			update(&peer)
+			manager.peers[peerKey(IP)] = peer
+		}
+		// silence makes a registered peer not heard from for a while
+		silence = func(manager *CommsManager, IP net.IP) {
+			updatePeer(manager, IP, func(peer *Peer) {
+				peer.LastSeen = peer.LastSeen.Add(-time.Hour)
+			})
+		}
+	)
+
+	t.Run("New computers are refused by default", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {})
+
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(newIP)), ErrMaxPeers)
+		assert.False(t, manager.seekingPeers(manager.Config()))
+	})
+
+	t.Run("New computers can replace the least recently seen peer", func(t *testing.T) {
+		manager, observer := makeFullManager(func(c *Config) {
+			c.FullPolicy = FullEvictLeastRecentlySeen
+		})
+
+		// The peers heard from lately aren't replaced
+		assert.False(t, manager.seekingPeers(manager.Config()))
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(newIP)), ErrMaxPeers)
+
+		silence(manager, ips[0])
+		assert.True(t, manager.seekingPeers(manager.Config()))
+		assert.Nil(t, manager.registerPeer(MakePeer(newIP)))
+		assert.True(t, manager.hasPeer(newIP))
+		assert.False(t, manager.hasPeer(ips[0]))
+		assert.Equal(t, map[string]EvictionReason{"192.168.0.20": EvictionReplaced}, observer.evicted)
+
+		// The replaced peer is left alone for a while
+		assert.True(t, manager.backingOff(ips[0]))
+	})
+
+	t.Run("New computers can replace the peer with the worst link", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {
+			c.FullPolicy = FullEvictWorstLink
+		})
+
+		// The peers losing few probes aren't replaced
+		updatePeer(manager, ips[1], func(peer *Peer) { peer.Link.observeLoss() })
+		assert.False(t, manager.seekingPeers(manager.Config()))
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(newIP)), ErrMaxPeers)
+
+		updatePeer(manager, ips[1], func(peer *Peer) {
+			peer.Link.observeLoss()
+			peer.Link.observeLoss()
+		})
+		assert.True(t, manager.seekingPeers(manager.Config()))
+		assert.Nil(t, manager.registerPeer(MakePeer(newIP)))
+		assert.True(t, manager.hasPeer(ips[0]))
+		assert.False(t, manager.hasPeer(ips[1]))
+	})
+
+	t.Run("New computers can replace peers with a lower priority", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {
+			c.FullPolicy = FullEvictLowestPriority
+			c.Priority = func(IP net.IP) int {
+				switch {
+				case IP.Equal(ips[1]):
+					return 1
+				case IP.Equal(newIP):
+					return 2
+				default:
+					return 3
+				}
+			}
+		})
+
+		// Full computers don't know the priority of those answering them
+		assert.False(t, manager.seekingPeers(manager.Config()))
+
+		assert.Nil(t, manager.registerPeer(MakePeer(newIP)))
+		assert.False(t, manager.hasPeer(ips[1]))
+
+		// Computers with the lowest priority are refused
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(ips[1])), ErrMaxPeers)
+	})
+
+	t.Run("Pinned peers have reserved slots", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {
+			c.MaxPeers = len(ips) + 1
+			c.PinnedPeers = IPList{pinnedIP}
+		})
+
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(newIP)), ErrMaxPeers)
+		assert.False(t, manager.hasRoomFor(newIP, manager.Config()))
+		assert.True(t, manager.hasRoomFor(pinnedIP, manager.Config()))
+		assert.Nil(t, manager.registerPeer(MakePeer(pinnedIP)))
+	})
+
+	t.Run("Pinned peers aren't replaced", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {
+			c.FullPolicy = FullEvictLeastRecentlySeen
+			c.PinnedPeers = IPList{ips[0]}
+		})
+		silence(manager, ips[0])
+		silence(manager, ips[1])
+
+		assert.Nil(t, manager.registerPeer(MakePeer(newIP)))
+		assert.True(t, manager.hasPeer(ips[0]))
+		assert.False(t, manager.hasPeer(ips[1]))
+	})
+
+	t.Run("Pinned peers replace others when the slots aren't reserved", func(t *testing.T) {
+		manager, _ := makeFullManager(func(c *Config) {})
+
+		config := manager.Config()
+		config.PinnedPeers = IPList{pinnedIP}
+		assert.Nil(t, manager.UpdateConfig(config))
+
+		assert.Nil(t, manager.registerPeer(MakePeer(pinnedIP)))
+		assert.False(t, manager.hasPeer(ips[0]))
+	})
+
+	t.Run("The priority policy needs priorities", func(t *testing.T) {
+		config := MakeDefaultConfig()
+		config.FullPolicy = FullEvictLowestPriority
+
+		assert.ErrorContains(t, config.Validate(), "needs a priority function")
+	})
+}
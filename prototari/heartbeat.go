@@ -0,0 +1,245 @@
+package prototari
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// minHeartbeatCheckInterval is the shortest time between the checks of the
+// peers' heartbeats.
+const minHeartbeatCheckInterval = 10 * time.Millisecond
+
+// heartbeatCheckInterval returns the time between the checks of the peers'
+// heartbeats: half the shortest of the heartbeat times, so that the inactive
+// peers and missed heartbeats are detected soon after they happen.
+func heartbeatCheckInterval(config Config) time.Duration {
+	return max(min(config.InactivePeerTime, config.HeartbeatMaxWait)/2, minHeartbeatCheckInterval)
+}
+
+// failureDetector returns the configured failure detector, or the threshold
+// detector if there's none.
+func (m *CommsManager) failureDetector(config Config) FailureDetector {
+	if config.FailureDetector != nil {
+		return config.FailureDetector
+	}
+
+	return m.thresholdDetector
+}
+
+// candidateTimeout returns the time a computer in the middle of the discovery
+// is remembered without hearing from it. Computers broadcast while they have
+// room for peers, so it's a few broadcast intervals.
+func candidateTimeout(config Config) time.Duration {
+	return 3 * config.BroadcastInterval
+}
+
+func (m *CommsManager) startHeartbeating() {
+	defer func() {
+		m.wg.Done()
+		m.logger.Debug("Heartbeat goroutine done")
+	}()
+
+	for {
+		select {
+		case <-time.After(heartbeatCheckInterval(m.Config())):
+			m.checkHeartbeats(time.Now())
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// checkHeartbeats sends a heartbeat to the peers that haven't been heard from
+// for the inactive peer time, and counts the heartbeats that weren't answered
+// in time. The failure detector's verdict decides which peers are suspect, and
+// which are evicted.
+//
+// With the indirect probes enabled, an unanswered heartbeat isn't counted as
+// missed until the peers asked to probe the target don't get an answer either.
+func (m *CommsManager) checkHeartbeats(now time.Time) {
+	var (
+		config       = m.Config()
+		detector     = m.failureDetector(config)
+		probed       []Peer
+		indirect     = make(map[string][]net.IP)
+		unresponsive []Peer
+		changes      []PeerStateChange
+		missed       uint64
+	)
+
+	m.peersMutex.Lock()
+
+	for key, peer := range m.peers {
+		var (
+			heartbeatExpired = !peer.heartbeatSent.IsZero() && now.Sub(peer.heartbeatSent) >= config.HeartbeatMaxWait
+			indirectExpired  = !peer.indirectProbeSent.IsZero() && now.Sub(peer.indirectProbeSent) >= config.HeartbeatMaxWait
+			helpers          []net.IP
+		)
+
+		if heartbeatExpired && peer.indirectProbeSent.IsZero() && config.IndirectProbes > 0 {
+			helpers = m.probeHelpers(peer.IP, config.IndirectProbes)
+		}
+
+		switch {
+		case len(helpers) > 0:
+			peer.indirectProbeSent = now
+			for _, helper := range helpers {
+				indirect[peerKey(helper)] = append(indirect[peerKey(helper)], peer.IP)
+			}
+
+		case heartbeatExpired && peer.indirectProbeSent.IsZero(), indirectExpired:
+			peer.heartbeatSent = time.Time{}
+			peer.indirectProbeSent = time.Time{}
+			peer.MissedHeartbeats++
+			missed++
+			detector.Missed(peer.IP, now)
+
+		case peer.heartbeatSent.IsZero() && now.Sub(peer.LastSeen) >= config.InactivePeerTime:
+			peer.heartbeatSent = now
+			probed = append(probed, peer)
+		}
+
+		switch detector.Verdict(peer.IP, now) {
+		case PeerDisconnected:
+			delete(m.peers, key)
+			unresponsive = append(unresponsive, peer)
+			continue
+		case PeerSuspect:
+			if peer.State == PeerConnected {
+				changes = append(changes, changeState(&peer, PeerSuspect))
+			}
+		case PeerConnected:
+			// The detector may stop suspecting a peer without hearing from
+			// it, when reconfigured for example
+			if peer.State == PeerSuspect {
+				changes = append(changes, changeState(&peer, PeerConnected))
+			}
+		}
+		m.peers[key] = peer
+	}
+
+	if len(changes) > 0 || len(unresponsive) > 0 {
+		m.publishPeers()
+	}
+	changes = append(changes, m.forgetCandidates(now.Add(-candidateTimeout(config)))...)
+	m.expireRelays(now.Add(-config.HeartbeatMaxWait))
+	m.expireProbes(now.Add(-config.HeartbeatMaxWait))
+	m.forgetRejections(now)
+
+	m.peersMutex.Unlock()
+
+	if config.IndirectProbes > 0 {
+		for _, change := range changes {
+			if change.To == PeerSuspect {
+				m.gossip.record(change.Peer.IP, PeerSuspect)
+			}
+		}
+		for _, peer := range unresponsive {
+			m.gossip.record(peer.IP, PeerDisconnected)
+		}
+	}
+
+	m.metrics.heartbeatsMissed.Add(missed)
+	m.notifyStateChanges(changes...)
+	for _, peer := range unresponsive {
+		m.notifyUnregistered(peer, EvictionUnresponsive)
+	}
+
+	for _, peer := range probed {
+		m.sendHeartbeat(peer.IP, nil)
+	}
+	for key, targets := range indirect {
+		for _, target := range targets {
+			m.sendProbe(indirectProbeMessage+target.String(), net.IP(key))
+		}
+	}
+}
+
+// handleProbe answers a heartbeat or indirect probe from a registered peer, and
+// acts on the membership updates piggybacked on it.
+func (m *CommsManager) handleProbe(from net.IP, kind messageKind, message []byte) {
+	switch kind {
+	case kindHeartbeat:
+		m.answerHeartbeat(from, message)
+	case kindHeartbeatReply:
+		m.heartbeatAnswered(from, message)
+	case kindIndirectProbe:
+		if target := probeTarget(message); target != nil {
+			m.relayProbe(from, target)
+		}
+	case kindIndirectProbeReply:
+		// The target answered another peer, so it's up
+		if target := probeTarget(message); target != nil {
+			m.peerSeen(target)
+		}
+	}
+
+	m.applyUpdates(from, message, kind == kindHeartbeat)
+}
+
+// peerSeen updates a registered peer after receiving a message from it: any
+// message proves the peer is up, so it counts as the answer to the pending
+// heartbeat, and to the indirect probes other peers asked for.
+// It returns the updated peer, and whether it's registered.
+func (m *CommsManager) peerSeen(IP net.IP) (Peer, bool) {
+	var (
+		key      = peerKey(IP)
+		detector = m.failureDetector(m.Config())
+		changes  []PeerStateChange
+	)
+
+	m.peersMutex.Lock()
+
+	peer, ok := m.peers[key]
+	if !ok {
+		m.peersMutex.Unlock()
+		return Peer{}, false
+	}
+
+	peer.LastSeen = time.Now()
+	detector.Heard(IP, peer.LastSeen)
+	peer.MissedHeartbeats = 0
+	peer.heartbeatSent = time.Time{}
+	peer.indirectProbeSent = time.Time{}
+	if peer.State == PeerSuspect {
+		changes = append(changes, changeState(&peer, PeerConnected))
+	}
+	m.peers[key] = peer
+
+	if len(changes) > 0 {
+		m.publishPeers()
+	}
+	requesters := m.takeRelays(IP)
+	m.peersMutex.Unlock()
+
+	m.notifyStateChanges(changes...)
+	for _, requester := range requesters {
+		m.sendProbe(indirectProbeReplyMessage+peer.IP.String(), requester)
+	}
+
+	return peer, true
+}
+
+// sendControl queues a protocol message to the computer with the given IP,
+// logging the failure.
+func (m *CommsManager) sendControl(message string, IP net.IP) {
+	m.sendStampedControl(message, IP, nil)
+}
+
+// sendStampedControl queues a control message like sendControl, with the
+// function stamping it right before it's written.
+func (m *CommsManager) sendStampedControl(message string, IP net.IP, stamp func(payload []byte, now time.Time) []byte) {
+	addr := m.peerAddress(IP)
+
+	if err := m.sendStamped(context.Background(), []byte(message), addr, PriorityControl, stamp); err != nil {
+		m.reportErr(&SendError{Addr: addr, Err: err})
+		m.logger.Warn(
+			"Couldn't send message",
+			slog.String("peer", IP.String()),
+			slog.String("type", kindOf([]byte(message)).String()),
+			slog.Any("error", err),
+		)
+	}
+}
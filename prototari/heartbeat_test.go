@@ -0,0 +1,156 @@
+package prototari
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stateChangesObserver struct {
+	NoopObserver
+	mutex   sync.Mutex
+	changes []string
+}
+
+func (o *stateChangesObserver) OnPeerStateChanged(change PeerStateChange) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.changes = append(o.changes, change.From.String()+" -> "+change.To.String())
+}
+
+func (o *stateChangesObserver) recorded() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]string(nil), o.changes...)
+}
+
+func TestHeartbeats(t *testing.T) {
+	var (
+		localAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peerAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		makeConfig = func(observer Observer) Config {
+			config := makeTestingConfig()
+			config.InactivePeerTime = time.Second
+			config.HeartbeatMaxWait = time.Second
+			config.MaxMissedHeartbeats = 2
+			config.Observer = observer
+			return config
+		}
+	)
+
+	t.Run("Peers missing the heartbeats become suspect and are evicted", func(t *testing.T) {
+		var (
+			observer stateChangesObserver
+			manager  = MakeManager(
+				&fakeBroadcastConn{localAddr: localAddr},
+				&fakeUnicastConn{localAddr: localAddr},
+				makeConfig(&observer),
+			)
+			peer = MakePeer(peerAddr.IP)
+			now  = peer.LastSeen
+		)
+		manager.registerPeer(peer)
+
+		manager.checkHeartbeats(now.Add(time.Second))
+		registered, _ := manager.Peer(peerAddr.IP)
+		assert.Equal(t, PeerConnected, registered.State)
+
+		manager.checkHeartbeats(now.Add(2 * time.Second))
+		registered, _ = manager.Peer(peerAddr.IP)
+		assert.Equal(t, PeerSuspect, registered.State)
+		assert.Equal(t, 1, registered.MissedHeartbeats)
+
+		// The second heartbeat is sent right away, and goes unanswered too
+		manager.checkHeartbeats(now.Add(2 * time.Second))
+		manager.checkHeartbeats(now.Add(3 * time.Second))
+		assert.False(t, manager.hasPeer(peerAddr.IP))
+
+		assert.Equal(t, []string{
+			"disconnected -> connected",
+			"connected -> suspect",
+			"suspect -> disconnected",
+		}, observer.recorded())
+		assert.Equal(t, uint64(2), manager.Metrics().HeartbeatsMissed)
+		assert.Equal(t, uint64(1), manager.Metrics().PeersEvicted)
+	})
+
+	t.Run("Hearing from a suspect peer makes it connected again", func(t *testing.T) {
+		var (
+			manager = MakeManager(
+				&fakeBroadcastConn{localAddr: localAddr},
+				&fakeUnicastConn{localAddr: localAddr},
+				makeConfig(nil),
+			)
+			peer = MakePeer(peerAddr.IP)
+			now  = peer.LastSeen
+		)
+		manager.registerPeer(peer)
+
+		manager.checkHeartbeats(now.Add(time.Second))
+		manager.checkHeartbeats(now.Add(2 * time.Second))
+		manager.peerSeen(peerAddr.IP)
+
+		registered, _ := manager.Peer(peerAddr.IP)
+		assert.Equal(t, PeerConnected, registered.State)
+		assert.Equal(t, 0, registered.MissedHeartbeats)
+	})
+
+	t.Run("Heartbeats from registered peers are answered", func(t *testing.T) {
+		var (
+			readCh   = make(chan fakeMsgRecord)
+			writeCh  = make(chan fakeMsgRecord, 1)
+			written  = make(chan fakeMsgRecord, 1)
+			unicConn = fakeUnicastConn{
+				localAddr: localAddr,
+				readChan:  readCh,
+				writeChan: writeCh,
+				written:   written,
+			}
+			manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig())
+		)
+		manager.registerPeer(MakePeer(peerAddr.IP))
+
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{IsUnicast: true, From: peerAddr, To: localAddr, Payload: []byte(heartbeatMessage)}
+
+		select {
+		case msg := <-writeCh:
+			assert.Equal(t, []byte(heartbeatReplyMessage), msg.Payload)
+			assert.True(t, peerAddr.IP.Equal(msg.To.IP))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Heartbeat not answered")
+		}
+	})
+
+	t.Run("Discovered computers go through the handshake states", func(t *testing.T) {
+		var (
+			observer  stateChangesObserver
+			readCh    = make(chan fakeMsgRecord)
+			broadConn = fakeBroadcastConn{localAddr: localAddr, readChan: readCh}
+			manager   = MakeManager(&broadConn, &fakeUnicastConn{localAddr: localAddr}, makeConfig(&observer))
+		)
+
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{From: peerAddr, Payload: []byte(discoveryMessage)}
+
+		assert.Eventually(t, func() bool {
+			return len(observer.recorded()) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Nil(t, manager.acceptHandshake(peerAddr, []byte(confirmationMessage)))
+		assert.Equal(t, []string{
+			"disconnected -> discovered",
+			"discovered -> handshake-pending",
+			"handshake-pending -> connected",
+		}, observer.recorded())
+	})
+}
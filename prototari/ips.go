@@ -60,3 +60,27 @@ func calculateBroadcastAddr(ipNet *net.IPNet) net.IP {
 
 	return broadcast
 }
+
+// interfaceName returns the name of the network interface with the given IP,
+// or an empty string if there's none.
+func interfaceName(IP net.IP) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(IP) {
+				return iface.Name
+			}
+		}
+	}
+
+	return ""
+}
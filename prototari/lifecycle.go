@@ -0,0 +1,74 @@
+package prototari
+
+import "errors"
+
+var (
+	// ErrRunning is returned when starting a CommsManager that's already running.
+	ErrRunning = errors.New("comms manager already running")
+
+	// ErrNotRunning is returned when stopping a CommsManager that isn't running.
+	ErrNotRunning = errors.New("comms manager not running")
+
+	// ErrClosed is returned when operating a CommsManager whose connections
+	// have been closed.
+	ErrClosed = errors.New("comms manager closed")
+)
+
+// lifecycleState is the state of a CommsManager's communications.
+//
+// A CommsManager starts in the new state and moves to running when started.
+// A running CommsManager can be stopped and started again any number of times.
+// Once closed, the connections are gone, and so it can't be started again.
+//
+//	New ---> Running <---> Stopped
+//	 |          |             |
+//	 +----------+-------------+---> Closed
+type lifecycleState int
+
+const (
+	lifecycleNew lifecycleState = iota
+	lifecycleRunning
+	lifecycleStopped
+	lifecycleClosed
+)
+
+func (s lifecycleState) String() string {
+	switch s {
+	case lifecycleNew:
+		return "new"
+	case lifecycleRunning:
+		return "running"
+	case lifecycleStopped:
+		return "stopped"
+	case lifecycleClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// canStart returns the error preventing the communications from starting in
+// this state, if any.
+func (s lifecycleState) canStart() error {
+	switch s {
+	case lifecycleRunning:
+		return ErrRunning
+	case lifecycleClosed:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// canStop returns the error preventing the communications from stopping in
+// this state, if any.
+func (s lifecycleState) canStop() error {
+	switch s {
+	case lifecycleRunning:
+		return nil
+	case lifecycleClosed:
+		return ErrClosed
+	default:
+		return ErrNotRunning
+	}
+}
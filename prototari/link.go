@@ -0,0 +1,268 @@
+package prototari
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The weights of the new samples in the smoothed link measurements, as in TCP
+// (RFC 6298) and RTP (RFC 3550).
+const (
+	rttGain    = 1.0 / 8
+	rttVarGain = 1.0 / 4
+	jitterGain = 1.0 / 16
+	lossGain   = 1.0 / 8
+)
+
+// LinkStats are the measurements of the link to a peer, taken from the round
+// trips of the heartbeats and pings.
+type LinkStats struct {
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration `json:"rtt"`
+	// RTTVar is the smoothed deviation of the round-trip times.
+	RTTVar time.Duration `json:"rttVar"`
+	// Jitter is the smoothed variation between consecutive round-trip times.
+	Jitter time.Duration `json:"jitter"`
+	// Loss is the recent fraction of unanswered probes, between 0 and 1.
+	Loss float64 `json:"loss"`
+	// ProbesSent is the number of heartbeats and pings sent to the peer.
+	ProbesSent uint64 `json:"probesSent"`
+	// ProbesLost is the number of heartbeats and pings the peer didn't answer
+	// in time.
+	ProbesLost uint64 `json:"probesLost"`
+
+	// lastRTT is the last round-trip time measured.
+	lastRTT time.Duration
+}
+
+// observeRTT adds the round-trip time of an answered probe to the
+// measurements.
+func (s *LinkStats) observeRTT(rtt time.Duration) {
+	if s.lastRTT == 0 {
+		s.RTT, s.RTTVar = rtt, rtt/2
+	} else {
+		s.RTTVar += time.Duration(rttVarGain * float64((s.RTT-rtt).Abs()-s.RTTVar))
+		s.RTT += time.Duration(rttGain * float64(rtt-s.RTT))
+		s.Jitter += time.Duration(jitterGain * float64((rtt-s.lastRTT).Abs()-s.Jitter))
+	}
+
+	s.lastRTT = rtt
+	s.Loss -= lossGain * s.Loss
+}
+
+// observeLoss counts an unanswered probe in the measurements.
+func (s *LinkStats) observeLoss() {
+	s.ProbesLost++
+	s.Loss += lossGain * (1 - s.Loss)
+}
+
+// A pendingProbe is a heartbeat or ping waiting for its answer.
+type pendingProbe struct {
+	IP   net.IP
+	sent time.Time
+	// answered receives the round-trip time, if someone is waiting for it.
+	answered chan time.Duration
+}
+
+// probeArgs returns the arguments following a probe's message in its header.
+func probeArgs(payload []byte) []string {
+	_, args, ok := strings.Cut(probeHeader(payload), "? ")
+	if !ok {
+		_, args, _ = strings.Cut(probeHeader(payload), "! ")
+	}
+
+	return strings.Fields(args)
+}
+
+// probeSeq returns the sequence number a heartbeat or its reply carry, if any.
+func probeSeq(payload []byte) (uint64, bool) {
+	args := probeArgs(payload)
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(args[0], 10, 64)
+	return seq, err == nil
+}
+
+// sendHeartbeat sends a heartbeat to the peer with the given IP, numbered so
+// that its reply can be told apart and timed. It returns the number.
+func (m *CommsManager) sendHeartbeat(IP net.IP, answered chan time.Duration) uint64 {
+	seq := m.probeSeq.Add(1)
+
+	m.pendingMutex.Lock()
+	m.pending[seq] = pendingProbe{IP: IP, sent: time.Now(), answered: answered}
+	m.pendingMutex.Unlock()
+
+	m.peersMutex.Lock()
+	if peer, ok := m.peers[peerKey(IP)]; ok {
+		peer.Link.ProbesSent++
+		m.peers[peerKey(IP)] = peer
+	}
+	m.peersMutex.Unlock()
+
+	// The round trip starts when the heartbeat is written, not when it's queued
+	stamp := func(payload []byte, now time.Time) []byte {
+		m.pendingMutex.Lock()
+		if probe, ok := m.pending[seq]; ok {
+			probe.sent = now
+			m.pending[seq] = probe
+		}
+		m.pendingMutex.Unlock()
+
+		return payload
+	}
+	m.sendStampedProbe(heartbeatMessage+" "+strconv.FormatUint(seq, 10), IP, stamp)
+
+	return seq
+}
+
+// answerHeartbeat replies to a heartbeat, echoing its number, if it has one,
+// followed by the times the heartbeat was received and the reply sent, so that
+// the peer can estimate the clock offset.
+func (m *CommsManager) answerHeartbeat(from net.IP, heartbeat []byte) {
+	received := time.Now()
+
+	seq, ok := probeSeq(heartbeat)
+	if !ok {
+		m.sendProbe(heartbeatReplyMessage, from)
+		return
+	}
+
+	// The reply time is added when the reply is written, so that the time it
+	// waited to be sent doesn't count as network delay
+	var (
+		reply = heartbeatReplyMessage + " " + strconv.FormatUint(seq, 10) + " " + formatTimestamp(received)
+		stamp = func(payload []byte, now time.Time) []byte {
+			return appendToHeader(payload, " "+formatTimestamp(now))
+		}
+	)
+	m.sendStampedProbe(reply, from, stamp)
+}
+
+// appendToHeader appends text to the first line of a probe, before the
+// piggybacked updates.
+func appendToHeader(payload []byte, text string) []byte {
+	header, updates, hasUpdates := bytes.Cut(payload, []byte("\n"))
+
+	stamped := append(append([]byte(nil), header...), text...)
+	if hasUpdates {
+		stamped = append(append(stamped, '\n'), updates...)
+	}
+
+	return stamped
+}
+
+// heartbeatAnswered measures the round trip of the heartbeat a reply answers,
+// and the clock offset if the reply carries its timestamps, and hands the
+// round-trip time to whoever's waiting for it.
+func (m *CommsManager) heartbeatAnswered(from net.IP, reply []byte) {
+	answered := time.Now()
+
+	seq, ok := probeSeq(reply)
+	if !ok {
+		return
+	}
+
+	m.pendingMutex.Lock()
+	probe, ok := m.pending[seq]
+	if ok && probe.IP.Equal(from) {
+		delete(m.pending, seq)
+	}
+	m.pendingMutex.Unlock()
+
+	if !ok || !probe.IP.Equal(from) {
+		return
+	}
+
+	var (
+		rtt               = answered.Sub(probe.sent)
+		received, replied time.Time
+		hasTimestamps     bool
+	)
+	if args := probeArgs(reply); len(args) == 3 {
+		var okReceived, okReplied bool
+		received, okReceived = parseTimestamp(args[1])
+		replied, okReplied = parseTimestamp(args[2])
+		hasTimestamps = okReceived && okReplied
+	}
+
+	m.peersMutex.Lock()
+	if peer, ok := m.peers[peerKey(from)]; ok {
+		peer.Link.observeRTT(rtt)
+		if hasTimestamps {
+			peer.observeClock(probe.sent, received, replied, answered)
+		}
+		m.peers[peerKey(from)] = peer
+	}
+	m.peersMutex.Unlock()
+
+	if probe.answered != nil {
+		probe.answered <- rtt
+	}
+}
+
+// expireProbes counts the probes sent before the given time as lost.
+// The caller must hold the peers mutex.
+func (m *CommsManager) expireProbes(since time.Time) {
+	m.pendingMutex.Lock()
+	defer m.pendingMutex.Unlock()
+
+	for seq, probe := range m.pending {
+		if !probe.sent.Before(since) {
+			continue
+		}
+
+		delete(m.pending, seq)
+		if peer, ok := m.peers[peerKey(probe.IP)]; ok {
+			peer.Link.observeLoss()
+			m.peers[peerKey(probe.IP)] = peer
+		}
+		if probe.answered != nil {
+			close(probe.answered)
+		}
+	}
+}
+
+// Ping sends a heartbeat to a registered peer and waits for its reply,
+// returning the round-trip time. The measurement is added to the peer's Link.
+//
+// It returns ErrUnknownPeer if the peer isn't registered, ErrNotRunning if the
+// communications aren't running, ErrNoReply if the reply doesn't arrive within
+// the heartbeat max wait time, and the context's error if it's done before.
+func (m *CommsManager) Ping(ctx context.Context, peer Peer) (time.Duration, error) {
+	if !m.hasPeer(peer.IP) {
+		return 0, ErrUnknownPeer
+	}
+
+	m.stateMutex.Lock()
+	running := m.state == lifecycleRunning
+	m.stateMutex.Unlock()
+
+	if !running {
+		return 0, ErrNotRunning
+	}
+
+	var (
+		answered = make(chan time.Duration, 1)
+		seq      = m.sendHeartbeat(peer.IP, answered)
+	)
+
+	select {
+	case rtt, ok := <-answered:
+		if !ok {
+			return 0, ErrNoReply
+		}
+		return rtt, nil
+	case <-ctx.Done():
+		m.pendingMutex.Lock()
+		delete(m.pending, seq)
+		m.pendingMutex.Unlock()
+
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,134 @@
+package prototari
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkStats(t *testing.T) {
+	t.Run("The first round trip sets the measurements", func(t *testing.T) {
+		var stats LinkStats
+		stats.observeRTT(100 * time.Millisecond)
+
+		assert.Equal(t, 100*time.Millisecond, stats.RTT)
+		assert.Equal(t, 50*time.Millisecond, stats.RTTVar)
+		assert.Equal(t, time.Duration(0), stats.Jitter)
+	})
+
+	t.Run("Later round trips are smoothed", func(t *testing.T) {
+		var stats LinkStats
+		stats.observeRTT(100 * time.Millisecond)
+		stats.observeRTT(180 * time.Millisecond)
+
+		assert.Equal(t, 110*time.Millisecond, stats.RTT)
+		assert.Equal(t, 57500*time.Microsecond, stats.RTTVar)
+		assert.Equal(t, 5*time.Millisecond, stats.Jitter)
+	})
+
+	t.Run("Loss is the recent fraction of lost probes", func(t *testing.T) {
+		var stats LinkStats
+		stats.observeLoss()
+		assert.Equal(t, lossGain, stats.Loss)
+		assert.Equal(t, uint64(1), stats.ProbesLost)
+
+		for range 50 {
+			stats.observeRTT(time.Millisecond)
+		}
+		assert.Less(t, stats.Loss, 0.001)
+	})
+}
+
+func TestPing(t *testing.T) {
+	var (
+		localAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peerAddr    = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		makeManager = func() (*CommsManager, chan<- fakeMsgRecord, <-chan fakeMsgRecord) {
+			var (
+				readCh   = make(chan fakeMsgRecord)
+				writeCh  = make(chan fakeMsgRecord, 16)
+				unicConn = fakeUnicastConn{
+					localAddr: localAddr,
+					readChan:  readCh,
+					writeChan: writeCh,
+					written:   make(chan fakeMsgRecord, 16),
+				}
+				manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig())
+			)
+			manager.registerPeer(MakePeer(peerAddr.IP))
+
+			return manager, readCh, writeCh
+		}
+	)
+
+	t.Run("Pinging a peer measures the round trip", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		manager.Start()
+		defer manager.Stop()
+
+		go func() {
+			msg := <-writeCh
+			assert.Equal(t, heartbeatMessage+" 1", string(msg.Payload))
+
+			time.Sleep(10 * time.Millisecond)
+			readCh <- fakeMsgRecord{IsUnicast: true, From: peerAddr, To: localAddr, Payload: []byte(heartbeatReplyMessage + " 1")}
+		}()
+
+		rtt, err := manager.Ping(context.Background(), MakePeer(peerAddr.IP))
+
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, rtt, 10*time.Millisecond)
+
+		peer, _ := manager.Peer(peerAddr.IP)
+		assert.Equal(t, rtt, peer.Link.RTT)
+		assert.Equal(t, uint64(1), peer.Link.ProbesSent)
+	})
+
+	t.Run("Unanswered pings are lost", func(t *testing.T) {
+		manager, _, _ := makeManager()
+		manager.Start()
+		defer manager.Stop()
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			manager.checkHeartbeats(time.Now().Add(manager.Config().HeartbeatMaxWait))
+		}()
+
+		_, err := manager.Ping(context.Background(), MakePeer(peerAddr.IP))
+
+		assert.ErrorIs(t, err, ErrNoReply)
+
+		peer, _ := manager.Peer(peerAddr.IP)
+		assert.Equal(t, uint64(1), peer.Link.ProbesLost)
+		assert.Equal(t, lossGain, peer.Link.Loss)
+	})
+
+	t.Run("Heartbeat replies echo the heartbeat's number", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{IsUnicast: true, From: peerAddr, To: localAddr, Payload: []byte(heartbeatMessage + " 42")}
+
+		select {
+		case msg := <-writeCh:
+			assert.True(t, strings.HasPrefix(string(msg.Payload), heartbeatReplyMessage+" 42 "))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Heartbeat not answered")
+		}
+	})
+
+	t.Run("Only registered peers can be pinged while running", func(t *testing.T) {
+		manager, _, _ := makeManager()
+
+		_, err := manager.Ping(context.Background(), MakePeer(net.ParseIP("192.168.0.99")))
+		assert.ErrorIs(t, err, ErrUnknownPeer)
+
+		_, err = manager.Ping(context.Background(), MakePeer(peerAddr.IP))
+		assert.ErrorIs(t, err, ErrNotRunning)
+	})
+}
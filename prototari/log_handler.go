@@ -0,0 +1,58 @@
+package prototari
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// A swapHandler is a slog.Handler passing the records to another handler,
+// which can be replaced while logging, so that the CommsManager's logger can
+// be reconfigured.
+type swapHandler struct {
+	current *atomic.Pointer[slog.Handler]
+	// derive applies the attributes and groups added to this handler to the
+	// current one.
+	derive func(slog.Handler) slog.Handler
+}
+
+func makeSwapHandler(handler slog.Handler) *swapHandler {
+	current := new(atomic.Pointer[slog.Handler])
+	current.Store(&handler)
+
+	return &swapHandler{
+		current: current,
+		derive:  func(h slog.Handler) slog.Handler { return h },
+	}
+}
+
+// swap replaces the handler the records are passed to.
+func (h *swapHandler) swap(handler slog.Handler) {
+	h.current.Store(&handler)
+}
+
+func (h *swapHandler) handler() slog.Handler {
+	return h.derive(*h.current.Load())
+}
+
+func (h *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler().Enabled(ctx, level)
+}
+
+func (h *swapHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &swapHandler{
+		current: h.current,
+		derive:  func(handler slog.Handler) slog.Handler { return h.derive(handler).WithAttrs(attrs) },
+	}
+}
+
+func (h *swapHandler) WithGroup(name string) slog.Handler {
+	return &swapHandler{
+		current: h.current,
+		derive:  func(handler slog.Handler) slog.Handler { return h.derive(handler).WithGroup(name) },
+	}
+}
@@ -0,0 +1,94 @@
+package prototari
+
+import (
+	"bytes"
+	"iter"
+	"net"
+	"slices"
+)
+
+// A LocalNode describes this computer in the protocol.
+type LocalNode struct {
+	// IP is this computer's private IP.
+	IP net.IP `json:"ip"`
+	// BroadcastAddr is the local address of the broadcast connection.
+	BroadcastAddr *net.UDPAddr `json:"broadcastAddr"`
+	// UnicastAddr is the local address of the unicast connection, which the
+	// peers send their messages to.
+	UnicastAddr *net.UDPAddr `json:"unicastAddr"`
+	// Interface is the name of the network interface with the private IP, or
+	// empty if it's unknown.
+	Interface string `json:"interface"`
+}
+
+// Self returns the description of this computer in the protocol.
+func (m *CommsManager) Self() LocalNode {
+	var (
+		broadcastAddr = m.broadcaster.LocalAddr()
+		unicastAddr   = m.unicaster.LocalAddr()
+		node          = LocalNode{
+			BroadcastAddr: broadcastAddr,
+			UnicastAddr:   unicastAddr,
+		}
+	)
+
+	if unicastAddr != nil {
+		node.IP = unicastAddr.IP
+		node.Interface = interfaceName(node.IP)
+	}
+
+	return node
+}
+
+// Peers returns a copy of the currently registered peers, sorted by IP.
+func (m *CommsManager) Peers() []Peer {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	return m.sortedPeers()
+}
+
+// Peer returns the registered peer with the given IP, and whether it's
+// registered.
+func (m *CommsManager) Peer(IP net.IP) (Peer, bool) {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	peer, ok := m.peers[peerKey(IP)]
+	return peer, ok
+}
+
+// AllPeers returns an iterator over a snapshot of the registered peers, sorted
+// by IP. The peers registered or evicted while iterating don't affect it.
+func (m *CommsManager) AllPeers() iter.Seq[Peer] {
+	return slices.Values(m.Peers())
+}
+
+// PeerIPs returns an iterator over the IPs of a snapshot of the registered
+// peers, sorted.
+func (m *CommsManager) PeerIPs() iter.Seq[net.IP] {
+	peers := m.Peers()
+
+	return func(yield func(net.IP) bool) {
+		for _, peer := range peers {
+			if !yield(peer.IP) {
+				return
+			}
+		}
+	}
+}
+
+// sortedPeers returns a copy of the registered peers, sorted by IP.
+// The caller must hold the peers mutex.
+func (m *CommsManager) sortedPeers() []Peer {
+	peers := make([]Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+
+	slices.SortFunc(peers, func(a, b Peer) int {
+		return bytes.Compare(a.IP.To16(), b.IP.To16())
+	})
+
+	return peers
+}
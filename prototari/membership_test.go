@@ -0,0 +1,65 @@
+package prototari
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembership(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		broadConn = fakeBroadcastConn{localAddr: &net.UDPAddr{IP: localAddr.IP, Port: BroadcastPort}}
+		unicConn  = fakeUnicastConn{localAddr: localAddr}
+		config    = makeTestingConfig()
+	)
+	config.MaxPeers = 3
+
+	manager := MakeManager(&broadConn, &unicConn, config)
+	for _, IP := range []string{"192.168.0.30", "192.168.0.4", "192.168.0.20"} {
+		manager.registerPeer(MakePeer(net.ParseIP(IP)))
+	}
+
+	t.Run("Peers are sorted by IP", func(t *testing.T) {
+		var IPs []string
+		for _, peer := range manager.Peers() {
+			IPs = append(IPs, peer.IP.String())
+		}
+
+		assert.Equal(t, []string{"192.168.0.4", "192.168.0.20", "192.168.0.30"}, IPs)
+	})
+
+	t.Run("Look up a peer by IP", func(t *testing.T) {
+		// IPv4 addresses match in both their 4 and 16 bytes representations
+		peer, ok := manager.Peer(net.ParseIP("192.168.0.20").To4())
+		assert.True(t, ok)
+		assert.True(t, peer.IP.Equal(net.ParseIP("192.168.0.20")))
+		assert.False(t, peer.Registered.IsZero())
+
+		_, ok = manager.Peer(net.ParseIP("192.168.0.21"))
+		assert.False(t, ok)
+	})
+
+	t.Run("Iterate the peers", func(t *testing.T) {
+		assert.Equal(t, manager.Peers(), slices.Collect(manager.AllPeers()))
+
+		var IPs []string
+		for IP := range manager.PeerIPs() {
+			IPs = append(IPs, IP.String())
+			if len(IPs) == 2 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"192.168.0.4", "192.168.0.20"}, IPs)
+	})
+
+	t.Run("Self describes the local connections", func(t *testing.T) {
+		self := manager.Self()
+
+		assert.True(t, self.IP.Equal(localAddr.IP))
+		assert.Equal(t, localAddr, self.UnicastAddr)
+		assert.Equal(t, BroadcastPort, self.BroadcastAddr.Port)
+	})
+}
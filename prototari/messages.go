@@ -1,6 +1,13 @@
 package prototari
 
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
 const (
+	// The discovery messages can be followed by the broadcaster's free slots.
 	discoveryMessage    string = "pelotari?"
 	discoveryMessageLen        = len(discoveryMessage)
 
@@ -9,4 +16,180 @@ const (
 
 	confirmationMessage    string = "dale!"
 	confirmationMessageLen        = len(confirmationMessage)
+
+	disconnectMessage    string = "agur!"
+	disconnectMessageLen        = len(disconnectMessage)
+
+	// The rejections are followed by the reason.
+	rejectMessage    string = "ez! "
+	rejectMessageLen        = len(rejectMessage)
+
+	// The heartbeats and their replies can be followed by a sequence number.
+	heartbeatMessage    string = "hor?"
+	heartbeatMessageLen        = len(heartbeatMessage)
+
+	heartbeatReplyMessage    string = "hemen nago!"
+	heartbeatReplyMessageLen        = len(heartbeatReplyMessage)
+
+	// The indirect probes are followed by the IP of the probed peer.
+	indirectProbeMessage    string = "hor dago? "
+	indirectProbeMessageLen        = len(indirectProbeMessage)
+
+	indirectProbeReplyMessage    string = "hor dago! "
+	indirectProbeReplyMessageLen        = len(indirectProbeReplyMessage)
+)
+
+// A Message is a payload received from a registered peer.
+type Message struct {
+	// From is the peer who sent the message.
+	From Peer
+
+	// Payload is the content of the message.
+	Payload []byte
+}
+
+// A messageKind is the type of a message exchanged by the protocol.
+type messageKind int
+
+const (
+	kindDiscovery messageKind = iota
+	kindResponse
+	kindConfirmation
+	kindDisconnect
+	kindHeartbeat
+	kindHeartbeatReply
+	kindIndirectProbe
+	kindIndirectProbeReply
+	kindReject
+	kindData
+
+	nOfMessageKinds = int(kindData) + 1
 )
+
+// kindOf returns the kind of a message given its payload.
+// Any payload that isn't a protocol message is a data message.
+//
+// The probes (heartbeats and indirect probes) can carry membership updates
+// after their first line, so only the first line identifies them, as long as
+// the rest are well-formed updates.
+func kindOf(payload []byte) messageKind {
+	switch string(payload) {
+	case discoveryMessage:
+		return kindDiscovery
+	case responseMessage:
+		return kindResponse
+	case confirmationMessage:
+		return kindConfirmation
+	case disconnectMessage:
+		return kindDisconnect
+	}
+
+	// The discovery messages can be followed by the free slots, the responses
+	// and confirmations by the protocol version, and the rejections by their
+	// reason
+	switch message := string(payload); {
+	case isNumbered(message, discoveryMessage, 1):
+		return kindDiscovery
+	case isNumbered(message, responseMessage, 1):
+		return kindResponse
+	case isNumbered(message, confirmationMessage, 1):
+		return kindConfirmation
+	case strings.HasPrefix(message, rejectMessage) && RejectReason(message[rejectMessageLen:]).known():
+		return kindReject
+	}
+
+	header, updates, hasUpdates := strings.Cut(string(payload), "\n")
+	if hasUpdates && !wellFormedUpdates(updates) {
+		return kindData
+	}
+
+	switch {
+	case isNumbered(header, heartbeatMessage, 1):
+		return kindHeartbeat
+	case isNumbered(header, heartbeatReplyMessage, 1, 3):
+		return kindHeartbeatReply
+	case isAbout(header, indirectProbeMessage):
+		return kindIndirectProbe
+	case isAbout(header, indirectProbeReplyMessage):
+		return kindIndirectProbeReply
+	default:
+		return kindData
+	}
+}
+
+// isAbout checks whether a text is the given message followed by an IP.
+func isAbout(text, message string) bool {
+	IP, ok := strings.CutPrefix(text, message)
+	return ok && net.ParseIP(IP) != nil
+}
+
+// isNumbered checks whether a text is the given message alone, or followed by
+// one of the given numbers of decimal arguments, separated by spaces.
+func isNumbered(text, message string, nOfArgs ...int) bool {
+	if text == message {
+		return true
+	}
+
+	rest, ok := strings.CutPrefix(text, message+" ")
+	if !ok {
+		return false
+	}
+
+	args := strings.Split(rest, " ")
+	for _, arg := range args {
+		if !isDecimal(arg) {
+			return false
+		}
+	}
+
+	return slices.Contains(nOfArgs, len(args))
+}
+
+// isDecimal checks whether a text is a non-negative decimal number.
+func isDecimal(text string) bool {
+	if text == "" {
+		return false
+	}
+
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// probeTarget returns the IP of the peer an indirect probe or its reply is
+// about, or nil if it's malformed.
+func probeTarget(payload []byte) net.IP {
+	header := probeHeader(payload)
+	return net.ParseIP(header[strings.LastIndexByte(header, ' ')+1:])
+}
+
+func (k messageKind) String() string {
+	switch k {
+	case kindDiscovery:
+		return "discovery"
+	case kindResponse:
+		return "response"
+	case kindConfirmation:
+		return "confirmation"
+	case kindDisconnect:
+		return "disconnect"
+	case kindHeartbeat:
+		return "heartbeat"
+	case kindHeartbeatReply:
+		return "heartbeat-reply"
+	case kindIndirectProbe:
+		return "indirect-probe"
+	case kindIndirectProbeReply:
+		return "indirect-probe-reply"
+	case kindReject:
+		return "reject"
+	case kindData:
+		return "data"
+	default:
+		return "unknown"
+	}
+}
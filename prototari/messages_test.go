@@ -0,0 +1,88 @@
+package prototari
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    messageKind
+	}{
+		{"pelotari?", kindDiscovery},
+		{"pelotari? 3", kindDiscovery},
+		{"pelotari? anyone", kindData},
+		{"hor?", kindHeartbeat},
+		{"hor? 42", kindHeartbeat},
+		{"hor? you there", kindData},
+		{"hor? 42 43", kindData},
+		{"hemen nago!", kindHeartbeatReply},
+		{"hemen nago! 42", kindHeartbeatReply},
+		{"hemen nago! 42 1700000000000000000 1700000000000000001", kindHeartbeatReply},
+		{"hemen nago! and well", kindData},
+		{"hemen nago! 42 43", kindData},
+		{"hor? 42\nsuspect 192.168.0.20\nconnected 192.168.0.21", kindHeartbeat},
+		{"hor?\nis anybody there", kindData},
+		{"hor dago? 192.168.0.20", kindIndirectProbe},
+		{"hor dago? anyone", kindData},
+		{"hor dago! 192.168.0.20\nsuspect 192.168.0.21", kindIndirectProbeReply},
+		{"hor dago! here I am", kindData},
+		{"aupa!", kindResponse},
+		{"aupa! 2", kindResponse},
+		{"aupa! x", kindData},
+		{"dale! 1", kindConfirmation},
+		{"dale! and done", kindData},
+		{"ez! full", kindReject},
+		{"ez! version", kindReject},
+		{"ez! hi", kindData},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, kindOf([]byte(test.payload)), test.payload)
+	}
+}
+
+func TestDataLikeProtocolMessages(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peerAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		readCh    = make(chan fakeMsgRecord)
+		unicConn  = fakeUnicastConn{
+			localAddr: localAddr,
+			readChan:  readCh,
+			writeChan: make(chan fakeMsgRecord, 16),
+			written:   make(chan fakeMsgRecord, 16),
+		}
+		manager = MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig())
+	)
+	manager.registerPeer(MakePeer(peerAddr.IP))
+	manager.Start()
+	defer manager.Stop()
+
+	// Only the payloads following the protocol grammar are protocol messages
+	for _, payload := range []string{
+		"pelotari? anyone",
+		"hor? you there",
+		"hemen nago! and well",
+		"hor?\nis anybody there",
+		"hor dago? anyone",
+		"hor dago! here I am",
+		"ez! hi",
+		"aupa! x",
+		"dale! and done",
+	} {
+		readCh <- fakeMsgRecord{IsUnicast: true, From: peerAddr, To: localAddr, Payload: []byte(payload)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		message, err := manager.Receive(ctx)
+		cancel()
+
+		assert.Nil(t, err, payload)
+		assert.Equal(t, payload, string(message.Payload))
+	}
+}
@@ -0,0 +1,232 @@
+package prototari
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// Metrics is a snapshot of the counters describing how the protocol behaves.
+// All the counters are cumulative since the CommsManager was made.
+type Metrics struct {
+	// BroadcastsSent is the number of discovery messages broadcast.
+	BroadcastsSent uint64
+
+	// HandshakesStarted is the number of handshakes this computer took part
+	// in: as a responder, when it answered a broadcast, and as a broadcaster,
+	// when it received an answer.
+	HandshakesStarted uint64
+	// HandshakesCompleted is the number of handshakes that ended with the
+	// other computer registered as peer.
+	HandshakesCompleted uint64
+	// HandshakesRejected is the number of handshakes that ended without
+	// registering the other computer, for example because the maximum number
+	// of peers was reached.
+	HandshakesRejected uint64
+
+	// HeartbeatsMissed is the number of heartbeats peers didn't respond to.
+	HeartbeatsMissed uint64
+
+	// Peers is the number of currently registered peers.
+	Peers int
+	// PeersRegistered is the number of peers registered.
+	PeersRegistered uint64
+	// PeersDisconnected is the number of peers that left sending the
+	// disconnect message.
+	PeersDisconnected uint64
+	// PeersEvicted is the number of peers removed by this computer.
+	PeersEvicted uint64
+
+	// ReadErrors is the number of failed reads, excluding timeouts.
+	ReadErrors uint64
+	// WriteErrors is the number of failed writes.
+	WriteErrors uint64
+
+	// Traffic is the number of packets and bytes sent and received, by
+	// message type.
+	Traffic map[string]TrafficMetrics
+
+	// RateLimits are the counters of the packets affected by the rate limits.
+	RateLimits RateLimitStats
+}
+
+// TrafficMetrics are the counters of the packets and bytes of a message type.
+type TrafficMetrics struct {
+	PacketsIn  uint64
+	BytesIn    uint64
+	PacketsOut uint64
+	BytesOut   uint64
+}
+
+// metrics are the live counters behind a Metrics snapshot.
+type metrics struct {
+	broadcastsSent      atomic.Uint64
+	handshakesStarted   atomic.Uint64
+	handshakesCompleted atomic.Uint64
+	handshakesRejected  atomic.Uint64
+	heartbeatsMissed    atomic.Uint64
+	peersRegistered     atomic.Uint64
+	peersDisconnected   atomic.Uint64
+	peersEvicted        atomic.Uint64
+	readErrors          atomic.Uint64
+	writeErrors         atomic.Uint64
+
+	packetsIn  [nOfMessageKinds]atomic.Uint64
+	bytesIn    [nOfMessageKinds]atomic.Uint64
+	packetsOut [nOfMessageKinds]atomic.Uint64
+	bytesOut   [nOfMessageKinds]atomic.Uint64
+}
+
+// The metrics are collected observing the protocol events.
+var _ Observer = (*metrics)(nil)
+
+func (c *metrics) OnPacketSent(to *net.UDPAddr, payload []byte) {
+	if to == nil {
+		c.broadcastsSent.Add(1)
+	}
+
+	kind := kindOf(payload)
+	c.packetsOut[kind].Add(1)
+	c.bytesOut[kind].Add(uint64(len(payload)))
+}
+
+func (c *metrics) OnPacketReceived(from *net.UDPAddr, payload []byte) {
+	kind := kindOf(payload)
+	c.packetsIn[kind].Add(1)
+	c.bytesIn[kind].Add(uint64(len(payload)))
+}
+
+func (c *metrics) OnHandshake(event HandshakeEvent) {
+	switch event.Stage {
+	case HandshakeStarted:
+		c.handshakesStarted.Add(1)
+	case HandshakeCompleted:
+		c.handshakesCompleted.Add(1)
+	case HandshakeRejected:
+		c.handshakesRejected.Add(1)
+	}
+}
+
+func (c *metrics) OnPeerRegistered(peer Peer) {
+	c.peersRegistered.Add(1)
+}
+
+func (c *metrics) OnPeerEvicted(peer Peer, reason EvictionReason) {
+	switch reason {
+	case EvictionDisconnected:
+		c.peersDisconnected.Add(1)
+	case EvictionStopped:
+		// Stopping deregisters the peers, but they're not evicted
+	default:
+		c.peersEvicted.Add(1)
+	}
+}
+
+func (c *metrics) OnPeerStateChanged(change PeerStateChange) {}
+
+func (c *metrics) OnError(err error) {
+	var (
+		readErr *ReadError
+		sendErr *SendError
+	)
+
+	switch {
+	case errors.As(err, &readErr):
+		c.readErrors.Add(1)
+	case errors.As(err, &sendErr):
+		c.writeErrors.Add(1)
+	}
+}
+
+// snapshot reads the counters.
+func (c *metrics) snapshot() Metrics {
+	traffic := make(map[string]TrafficMetrics, nOfMessageKinds)
+	for kind := range messageKind(nOfMessageKinds) {
+		traffic[kind.String()] = TrafficMetrics{
+			PacketsIn:  c.packetsIn[kind].Load(),
+			BytesIn:    c.bytesIn[kind].Load(),
+			PacketsOut: c.packetsOut[kind].Load(),
+			BytesOut:   c.bytesOut[kind].Load(),
+		}
+	}
+
+	return Metrics{
+		BroadcastsSent:      c.broadcastsSent.Load(),
+		HandshakesStarted:   c.handshakesStarted.Load(),
+		HandshakesCompleted: c.handshakesCompleted.Load(),
+		HandshakesRejected:  c.handshakesRejected.Load(),
+		HeartbeatsMissed:    c.heartbeatsMissed.Load(),
+		PeersRegistered:     c.peersRegistered.Load(),
+		PeersDisconnected:   c.peersDisconnected.Load(),
+		PeersEvicted:        c.peersEvicted.Load(),
+		ReadErrors:          c.readErrors.Load(),
+		WriteErrors:         c.writeErrors.Load(),
+		Traffic:             traffic,
+	}
+}
+
+// Metrics returns a snapshot of the protocol's counters.
+func (m *CommsManager) Metrics() Metrics {
+	snapshot := m.metrics.snapshot()
+	snapshot.Peers = m.NOfPeers()
+	snapshot.RateLimits = m.RateLimitStats()
+
+	return snapshot
+}
+
+// MetricsHandler returns an HTTP handler serving the protocol's counters in
+// the Prometheus text exposition format.
+// It's up to the application to mount it, preferably on a local address.
+func (m *CommsManager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := m.Metrics().WritePrometheus(w); err != nil {
+			m.logger.Warn("Couldn't write the metrics", slog.Any("error", err))
+		}
+	})
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (s Metrics) WritePrometheus(w io.Writer) error {
+	var (
+		buff    = bufio.NewWriter(w)
+		counter = func(name, help string, value uint64) {
+			fmt.Fprintf(buff, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+		}
+		byType = func(name, help string, value func(TrafficMetrics) uint64) {
+			fmt.Fprintf(buff, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+			for kind := range messageKind(nOfMessageKinds) {
+				fmt.Fprintf(buff, "%s{type=%q} %d\n", name, kind, value(s.Traffic[kind.String()]))
+			}
+		}
+	)
+
+	counter("pelotari_broadcasts_sent_total", "Discovery messages broadcast.", s.BroadcastsSent)
+	counter("pelotari_handshakes_started_total", "Handshakes started.", s.HandshakesStarted)
+	counter("pelotari_handshakes_completed_total", "Handshakes that registered the peer.", s.HandshakesCompleted)
+	counter("pelotari_handshakes_rejected_total", "Handshakes that didn't register the peer.", s.HandshakesRejected)
+	counter("pelotari_heartbeats_missed_total", "Heartbeats peers didn't respond to.", s.HeartbeatsMissed)
+
+	fmt.Fprintf(buff, "# HELP pelotari_peers Registered peers.\n# TYPE pelotari_peers gauge\npelotari_peers %d\n", s.Peers)
+	counter("pelotari_peers_registered_total", "Peers registered.", s.PeersRegistered)
+	counter("pelotari_peers_disconnected_total", "Peers that left sending the disconnect message.", s.PeersDisconnected)
+	counter("pelotari_peers_evicted_total", "Peers removed by this computer.", s.PeersEvicted)
+
+	counter("pelotari_read_errors_total", "Failed reads, excluding timeouts.", s.ReadErrors)
+	counter("pelotari_write_errors_total", "Failed writes.", s.WriteErrors)
+
+	byType("pelotari_packets_in_total", "Packets received, by message type.", func(t TrafficMetrics) uint64 { return t.PacketsIn })
+	byType("pelotari_bytes_in_total", "Bytes received, by message type.", func(t TrafficMetrics) uint64 { return t.BytesIn })
+	byType("pelotari_packets_out_total", "Packets sent, by message type.", func(t TrafficMetrics) uint64 { return t.PacketsOut })
+	byType("pelotari_bytes_out_total", "Bytes sent, by message type.", func(t TrafficMetrics) uint64 { return t.BytesOut })
+
+	counter("pelotari_outbound_delayed_total", "Packets delayed by the outgoing rate limits.", s.RateLimits.OutboundDelayed)
+	counter("pelotari_inbound_dropped_total", "Packets dropped by the inbound rate limit.", s.RateLimits.InboundDropped)
+
+	return buff.Flush()
+}
@@ -0,0 +1,43 @@
+package prototari
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Traffic is counted by message type", func(t *testing.T) {
+		var counters metrics
+
+		counters.OnPacketSent(nil, []byte(discoveryMessage))
+		counters.OnPacketReceived(nil, []byte(responseMessage))
+		counters.OnPacketReceived(nil, []byte("Hello"))
+
+		snapshot := counters.snapshot()
+		assert.Equal(t, TrafficMetrics{PacketsOut: 1, BytesOut: uint64(discoveryMessageLen)}, snapshot.Traffic["discovery"])
+		assert.Equal(t, TrafficMetrics{PacketsIn: 1, BytesIn: uint64(responseMessageLen)}, snapshot.Traffic["response"])
+		assert.Equal(t, TrafficMetrics{PacketsIn: 1, BytesIn: 5}, snapshot.Traffic["data"])
+	})
+
+	t.Run("Prometheus text exposition", func(t *testing.T) {
+		var (
+			counters metrics
+			out      strings.Builder
+		)
+
+		counters.broadcastsSent.Add(2)
+		counters.OnPacketSent(nil, []byte(discoveryMessage))
+
+		snapshot := counters.snapshot()
+		snapshot.Peers = 2
+		assert.Nil(t, snapshot.WritePrometheus(&out))
+
+		text := out.String()
+		assert.Contains(t, text, "# TYPE pelotari_broadcasts_sent_total counter\npelotari_broadcasts_sent_total 3\n")
+		assert.Contains(t, text, "# TYPE pelotari_peers gauge\npelotari_peers 2\n")
+		assert.Contains(t, text, "pelotari_packets_out_total{type=\"discovery\"} 1\n")
+		assert.Contains(t, text, "pelotari_packets_out_total{type=\"data\"} 0\n")
+	})
+}
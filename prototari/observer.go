@@ -0,0 +1,225 @@
+package prototari
+
+import "net"
+
+// An Observer is notified of the protocol events as they happen.
+// It allows instrumenting the protocol (metrics, tracing, audit logging...)
+// without changing it.
+//
+// The methods are called from the CommsManager's goroutines, so they must be
+// safe for concurrent use, and they should return quickly, as the protocol
+// waits for them.
+// Embed NoopObserver to implement only the methods of interest.
+type Observer interface {
+	// OnPacketSent is called after a packet is written. The destination
+	// address is nil for discovery broadcasts.
+	OnPacketSent(to *net.UDPAddr, payload []byte)
+
+	// OnPacketReceived is called when a packet is read, before it's handled.
+	// The packets dropped by the inbound rate limit aren't reported; they're
+	// counted in the RateLimitStats. The payload is only valid during the
+	// call.
+	OnPacketReceived(from *net.UDPAddr, payload []byte)
+
+	// OnHandshake is called as the handshake with another computer advances.
+	OnHandshake(event HandshakeEvent)
+
+	// OnPeerRegistered is called when a peer is registered.
+	OnPeerRegistered(peer Peer)
+
+	// OnPeerEvicted is called when a registered peer is removed.
+	OnPeerEvicted(peer Peer, reason EvictionReason)
+
+	// OnPeerStateChanged is called when a computer moves to another stage of
+	// its lifecycle as a peer. The registrations and evictions are notified
+	// both as state changes and by their own methods.
+	OnPeerStateChanged(change PeerStateChange)
+
+	// OnError is called with the errors also delivered by the Errors channel.
+	OnError(err error)
+}
+
+// A HandshakeRole is the part a computer plays in a handshake.
+type HandshakeRole int
+
+const (
+	// HandshakeBroadcaster is the role of the computer whose discovery message
+	// was answered.
+	HandshakeBroadcaster HandshakeRole = iota
+	// HandshakeResponder is the role of the computer that answered the
+	// discovery message.
+	HandshakeResponder
+)
+
+func (r HandshakeRole) String() string {
+	switch r {
+	case HandshakeBroadcaster:
+		return "broadcaster"
+	case HandshakeResponder:
+		return "responder"
+	default:
+		return "unknown"
+	}
+}
+
+// A HandshakeStage is how far a handshake has gone.
+type HandshakeStage int
+
+const (
+	// HandshakeStarted is the stage of a handshake whose response was sent,
+	// by the responder, or received, by the broadcaster.
+	HandshakeStarted HandshakeStage = iota
+	// HandshakeCompleted is the stage of a handshake that ended with the other
+	// computer registered as peer.
+	HandshakeCompleted
+	// HandshakeRejected is the stage of a handshake that ended without
+	// registering the other computer.
+	HandshakeRejected
+)
+
+func (s HandshakeStage) String() string {
+	switch s {
+	case HandshakeStarted:
+		return "started"
+	case HandshakeCompleted:
+		return "completed"
+	case HandshakeRejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
+// A HandshakeEvent describes a step of the handshake with another computer.
+type HandshakeEvent struct {
+	// IP is the other computer's IP.
+	IP net.IP
+	// Role is the part this computer plays in the handshake.
+	Role HandshakeRole
+	// Stage is how far the handshake has gone.
+	Stage HandshakeStage
+	// Err is the reason a handshake was rejected.
+	Err error
+}
+
+// An EvictionReason is why a registered peer was removed.
+type EvictionReason int
+
+const (
+	// EvictionDisconnected is the reason for peers that sent the disconnect
+	// message.
+	EvictionDisconnected EvictionReason = iota
+	// EvictionStopped is the reason for the peers deregistered when the
+	// communications stop.
+	EvictionStopped
+	// EvictionExcess is the reason for the peers evicted when MaxPeers is
+	// reduced below the number of registered peers.
+	EvictionExcess
+	// EvictionNotAdmitted is the reason for the peers evicted when the
+	// admission filter changes to reject them.
+	EvictionNotAdmitted
+	// EvictionRequested is the reason for the peers evicted by calling
+	// Disconnect.
+	EvictionRequested
+	// EvictionUnresponsive is the reason for the peers that missed the
+	// maximum number of heartbeats.
+	EvictionUnresponsive
+	// EvictionRejected is the reason for the peers that rejected the
+	// handshake after being registered.
+	EvictionRejected
+	// EvictionReplaced is the reason for the peers replaced by another
+	// following the FullPolicy.
+	EvictionReplaced
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionDisconnected:
+		return "disconnected"
+	case EvictionStopped:
+		return "stopped"
+	case EvictionExcess:
+		return "excess"
+	case EvictionNotAdmitted:
+		return "not-admitted"
+	case EvictionRequested:
+		return "requested"
+	case EvictionUnresponsive:
+		return "unresponsive"
+	case EvictionRejected:
+		return "rejected"
+	case EvictionReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// NoopObserver is an Observer that does nothing.
+// It's meant to be embedded by observers that implement only some methods.
+type NoopObserver struct{}
+
+func (NoopObserver) OnPacketSent(to *net.UDPAddr, payload []byte)       {}
+func (NoopObserver) OnPacketReceived(from *net.UDPAddr, payload []byte) {}
+func (NoopObserver) OnHandshake(event HandshakeEvent)                   {}
+func (NoopObserver) OnPeerRegistered(peer Peer)                         {}
+func (NoopObserver) OnPeerEvicted(peer Peer, reason EvictionReason)     {}
+func (NoopObserver) OnPeerStateChanged(change PeerStateChange)          {}
+func (NoopObserver) OnError(err error)                                  {}
+
+// MultiObserver returns an Observer that notifies all the given observers, in
+// order. Nil observers are skipped.
+func MultiObserver(observers ...Observer) Observer {
+	multi := make(multiObserver, 0, len(observers))
+	for _, observer := range observers {
+		if observer != nil {
+			multi = append(multi, observer)
+		}
+	}
+
+	return multi
+}
+
+type multiObserver []Observer
+
+func (mo multiObserver) OnPacketSent(to *net.UDPAddr, payload []byte) {
+	for _, o := range mo {
+		o.OnPacketSent(to, payload)
+	}
+}
+
+func (mo multiObserver) OnPacketReceived(from *net.UDPAddr, payload []byte) {
+	for _, o := range mo {
+		o.OnPacketReceived(from, payload)
+	}
+}
+
+func (mo multiObserver) OnHandshake(event HandshakeEvent) {
+	for _, o := range mo {
+		o.OnHandshake(event)
+	}
+}
+
+func (mo multiObserver) OnPeerRegistered(peer Peer) {
+	for _, o := range mo {
+		o.OnPeerRegistered(peer)
+	}
+}
+
+func (mo multiObserver) OnPeerEvicted(peer Peer, reason EvictionReason) {
+	for _, o := range mo {
+		o.OnPeerEvicted(peer, reason)
+	}
+}
+
+func (mo multiObserver) OnPeerStateChanged(change PeerStateChange) {
+	for _, o := range mo {
+		o.OnPeerStateChanged(change)
+	}
+}
+
+func (mo multiObserver) OnError(err error) {
+	for _, o := range mo {
+		o.OnError(err)
+	}
+}
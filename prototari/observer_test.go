@@ -0,0 +1,32 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// registrationsObserver records the registered peers.
+type registrationsObserver struct {
+	NoopObserver
+	registered []Peer
+}
+
+func (o *registrationsObserver) OnPeerRegistered(peer Peer) {
+	o.registered = append(o.registered, peer)
+}
+
+func TestMultiObserver(t *testing.T) {
+	var (
+		first, second registrationsObserver
+		observer      = MultiObserver(&first, nil, &second)
+		peer          = MakePeer(net.IP([]byte("192.168.0.10")))
+	)
+
+	observer.OnPeerRegistered(peer)
+	observer.OnError(nil)
+
+	assert.Equal(t, []Peer{peer}, first.registered)
+	assert.Equal(t, []Peer{peer}, second.registered)
+}
@@ -9,13 +9,41 @@ import (
 // can talk to and receive messages from.
 type Peer struct {
 	// The peer's IP address inside the private network.
-	IP net.IP
+	IP net.IP `json:"ip"`
 
 	// The time when the last message from the peer was received.
-	LastSeen time.Time
+	LastSeen time.Time `json:"lastSeen"`
 
-	// The number of heartbeats the peer hasn't responded to.
-	MissedHeartbeats int
+	// The time when the peer was registered.
+	Registered time.Time `json:"registered"`
+
+	// The number of heartbeats in a row the peer hasn't responded to.
+	MissedHeartbeats int `json:"missedHeartbeats"`
+
+	// The stage of the peer's lifecycle.
+	State PeerState `json:"state"`
+
+	// The measurements of the link to the peer.
+	Link LinkStats `json:"link"`
+
+	// How far ahead the peer's clock is from the local clock, estimated from
+	// the heartbeats' round trips. Use LocalTime to convert the peer's times.
+	ClockOffset time.Duration `json:"clockOffset"`
+
+	// The maximum error of the ClockOffset, half the network delay of the
+	// round trip it was estimated from.
+	ClockUncertainty time.Duration `json:"clockUncertainty"`
+
+	// clock holds the latest clock offset samples.
+	clock clockFilter
+
+	// heartbeatSent is when the unanswered heartbeat was sent to the peer, or
+	// zero if there's none.
+	heartbeatSent time.Time
+
+	// indirectProbeSent is when other peers were asked to probe the peer
+	// because it didn't answer the heartbeat, or zero if they weren't.
+	indirectProbeSent time.Time
 }
 
 func MakePeer(IP net.IP) Peer {
@@ -26,6 +54,8 @@ func MakePeer(IP net.IP) Peer {
 	}
 }
 
+// Address returns the peer's unicast address, assuming it uses the default
+// UnicastPort.
 func (p Peer) Address() *net.UDPAddr {
 	return &net.UDPAddr{
 		IP:   p.IP,
@@ -38,3 +68,14 @@ func (p Peer) Address() *net.UDPAddr {
 func (p Peer) Equal(other Peer) bool {
 	return p.IP.Equal(other.IP)
 }
+
+// peerKey returns the key identifying an IP in the maps of peers.
+// The same IPv4 address can be represented using 4 or 16 bytes, and so the
+// 16 bytes representation is used.
+func peerKey(IP net.IP) string {
+	if ip16 := IP.To16(); ip16 != nil {
+		return string(ip16)
+	}
+
+	return string(IP)
+}
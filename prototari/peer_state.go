@@ -0,0 +1,215 @@
+package prototari
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// maxCandidates is the number of computers in the middle of the discovery the
+// CommsManager keeps track of. Past it, the broadcasts of new computers aren't
+// answered, and the rest are registered without going through the discovered
+// and handshake pending states.
+const maxCandidates = 1024
+
+// A PeerState is the stage of a computer's lifecycle as a peer.
+//
+// A computer that hasn't been heard of, or was removed, is disconnected.
+// When its discovery message is received, it's discovered, and once answered,
+// this computer waits for the confirmation with the handshake pending.
+// Registered peers are connected, and become suspect when they miss a
+// heartbeat, until they're heard from again:
+//
+//	Disconnected --> Discovered --> HandshakePending
+//	     ^ |                               |
+//	     | +------------+  +---------------+
+//	     |              V  V
+//	     +-------- Connected <--> Suspect
+//	     |                           |
+//	     +---------------------------+
+//
+// Any state can go back to disconnected: peers leave or are evicted, and the
+// discovered computers that don't complete the handshake are forgotten.
+type PeerState int
+
+const (
+	// PeerDisconnected is the state of the computers that aren't peers.
+	PeerDisconnected PeerState = iota
+	// PeerDiscovered is the state of the computers whose discovery message
+	// was received.
+	PeerDiscovered
+	// PeerHandshakePending is the state of the computers whose discovery
+	// message was answered, while the confirmation is awaited.
+	PeerHandshakePending
+	// PeerConnected is the state of the registered peers.
+	PeerConnected
+	// PeerSuspect is the state of the registered peers that missed the last
+	// heartbeat.
+	PeerSuspect
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerDisconnected:
+		return "disconnected"
+	case PeerDiscovered:
+		return "discovered"
+	case PeerHandshakePending:
+		return "handshake-pending"
+	case PeerConnected:
+		return "connected"
+	case PeerSuspect:
+		return "suspect"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the state as its name, like "handshake-pending".
+func (s PeerState) MarshalText() ([]byte, error) {
+	if s < PeerDisconnected || s > PeerSuspect {
+		return nil, fmt.Errorf("unknown peer state %d", int(s))
+	}
+
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state from its name, like "handshake-pending".
+func (s *PeerState) UnmarshalText(text []byte) error {
+	for state := PeerDisconnected; state <= PeerSuspect; state++ {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown peer state %q", text)
+}
+
+// A PeerStateChange is a transition in a computer's lifecycle as a peer.
+type PeerStateChange struct {
+	// Peer is the computer, already in the new state.
+	Peer Peer
+	// From is the state the computer left.
+	From PeerState
+	// To is the state the computer entered.
+	To PeerState
+}
+
+// changeState moves the peer to a new state, returning the change.
+func changeState(peer *Peer, to PeerState) PeerStateChange {
+	from := peer.State
+	peer.State = to
+
+	return PeerStateChange{Peer: *peer, From: from, To: to}
+}
+
+// notifyStateChanges logs the state changes and notifies the observer.
+// It must be called without holding the peers mutex.
+func (m *CommsManager) notifyStateChanges(changes ...PeerStateChange) {
+	for _, change := range changes {
+		m.logger.Debug(
+			"Peer state changed",
+			slog.String("peer", change.Peer.IP.String()),
+			slog.String("from", change.From.String()),
+			slog.String("to", change.To.String()),
+		)
+		m.observer.OnPeerStateChanged(change)
+	}
+}
+
+// advanceCandidate moves a computer that isn't registered to the discovered or
+// handshake pending state, refreshing its last seen time. Computers can't go
+// back from handshake pending to discovered.
+// It returns whether the computer is tracked: not if it's registered, or
+// there are already maxCandidates.
+func (m *CommsManager) advanceCandidate(IP net.IP, to PeerState) bool {
+	var (
+		key     = peerKey(IP)
+		changes []PeerStateChange
+	)
+
+	m.peersMutex.Lock()
+	if _, registered := m.peers[key]; registered {
+		m.peersMutex.Unlock()
+		return false
+	}
+
+	candidate, ok := m.candidates[key]
+	if !ok {
+		if len(m.candidates) >= maxCandidates {
+			m.peersMutex.Unlock()
+			return false
+		}
+		candidate = Peer{IP: IP, State: PeerDisconnected}
+	}
+
+	candidate.LastSeen = time.Now()
+	if candidate.State < to {
+		changes = append(changes, changeState(&candidate, to))
+	}
+	m.candidates[key] = candidate
+	m.peersMutex.Unlock()
+
+	m.notifyStateChanges(changes...)
+
+	return true
+}
+
+// respondedTo returns whether this computer responded to the broadcast of the
+// computer with the given IP, and is waiting for its confirmation.
+func (m *CommsManager) respondedTo(IP net.IP) bool {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	return m.candidates[peerKey(IP)].State == PeerHandshakePending
+}
+
+// responding returns whether this computer is about to respond to the
+// broadcast of the computer with the given IP.
+func (m *CommsManager) responding(IP net.IP) bool {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	return m.candidates[peerKey(IP)].State == PeerDiscovered
+}
+
+// initiates returns whether this computer is the broadcaster of the handshake
+// with the computer with the given IP when they discover each other at once.
+// The computers are identified by their IPs, and the one with the lower IP
+// initiates it.
+func (m *CommsManager) initiates(IP net.IP) bool {
+	return peerKey(m.unicaster.LocalAddr().IP) < peerKey(IP)
+}
+
+// forgetCandidate removes a computer that won't complete the discovery and
+// returns whether it was in the middle of it.
+func (m *CommsManager) forgetCandidate(IP net.IP) bool {
+	m.peersMutex.Lock()
+	candidate, ok := m.candidates[peerKey(IP)]
+	delete(m.candidates, peerKey(IP))
+	m.peersMutex.Unlock()
+
+	if ok {
+		m.notifyStateChanges(changeState(&candidate, PeerDisconnected))
+	}
+
+	return ok
+}
+
+// forgetCandidates removes the computers that haven't completed the discovery
+// since the given time.
+// The caller must hold the peers mutex.
+func (m *CommsManager) forgetCandidates(since time.Time) []PeerStateChange {
+	var changes []PeerStateChange
+
+	for key, candidate := range m.candidates {
+		if candidate.LastSeen.Before(since) {
+			delete(m.candidates, key)
+			changes = append(changes, changeState(&candidate, PeerDisconnected))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,295 @@
+package prototari
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxTrackedSources is the number of source IPs the inbound limiter keeps
+	// buckets for. Past it, the ones that are no longer limiting are pruned,
+	// and the new sources share a single bucket until there's room.
+	maxTrackedSources = 1024
+
+	// pruneInterval is the shortest time between two prunes of the inbound
+	// limiter's buckets, so that a flood from new sources doesn't go through
+	// all of them for every packet.
+	pruneInterval = time.Second
+)
+
+// A RateLimit is a token bucket limit: on average, Rate tokens per second are
+// allowed, with bursts of up to Burst tokens.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// enabled checks whether the limit is enabled.
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// validate checks that the limit isn't negative.
+func (l RateLimit) validate(name string) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("%s rate limit can't be negative, got rate %g and burst %d", name, l.Rate, l.Burst)
+	}
+
+	return nil
+}
+
+// RateLimits are the limits to the traffic the CommsManager sends and accepts.
+// The outgoing limits apply to the application's messages: the protocol's own
+// messages, like the heartbeats, aren't limited, nor count towards the limits.
+type RateLimits struct {
+	// PeerPackets limits the number of packets per second sent to each peer.
+	PeerPackets RateLimit `json:"peer-packets"`
+	// PeerBytes limits the number of bytes per second sent to each peer.
+	PeerBytes RateLimit `json:"peer-bytes"`
+	// GlobalPackets limits the number of packets per second sent to all peers.
+	GlobalPackets RateLimit `json:"global-packets"`
+	// GlobalBytes limits the number of bytes per second sent to all peers.
+	GlobalBytes RateLimit `json:"global-bytes"`
+	// InboundPackets limits the number of packets per second accepted from
+	// each source IP, both in the broadcast and unicast connections.
+	InboundPackets RateLimit `json:"inbound-packets"`
+}
+
+// RateLimitStats are the counters of the packets affected by the rate limits.
+type RateLimitStats struct {
+	// OutboundDelayed is the number of sent packets that were delayed to
+	// respect the outgoing limits.
+	OutboundDelayed uint64
+	// InboundDropped is the number of received packets that were dropped for
+	// exceeding the inbound limit.
+	InboundDropped uint64
+}
+
+// A tokenBucket allows a rate of tokens per second, with bursts of up to its
+// capacity. A nil bucket allows everything.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// makeTokenBucket returns a full bucket for the limit, or nil if the limit is
+// disabled.
+func makeTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if !limit.enabled() {
+		return nil
+	}
+
+	// A bucket must hold at least one token for anything to ever be allowed
+	burst := math.Max(float64(limit.Burst), 1)
+
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// refill adds the tokens accrued since the last refill.
+// The caller must hold the mutex.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// allow takes n tokens from the bucket if there are enough of them, returning
+// whether they were taken.
+func (b *tokenBucket) allow(n float64, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+	if b.tokens < n {
+		return false
+	}
+
+	b.tokens -= n
+	return true
+}
+
+// reserve takes n tokens from the bucket, even if there aren't enough of them,
+// and returns how long the caller has to wait for the bucket to cover them.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// isFull checks whether the bucket has refilled completely, in which case
+// it's equivalent to a new bucket.
+func (b *tokenBucket) isFull(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// outboundLimits are the configured outgoing limits, with the buckets shared by
+// all the destinations. They're replaced as a whole when the limits change.
+type outboundLimits struct {
+	peerPackets, peerBytes     RateLimit
+	globalPackets, globalBytes *tokenBucket
+}
+
+func makeOutboundLimits(limits RateLimits, now time.Time) *outboundLimits {
+	return &outboundLimits{
+		peerPackets:   limits.PeerPackets,
+		peerBytes:     limits.PeerBytes,
+		globalPackets: makeTokenBucket(limits.GlobalPackets, now),
+		globalBytes:   makeTokenBucket(limits.GlobalBytes, now),
+	}
+}
+
+// An outboundLimiter delays the packets sent to a destination to respect both
+// its own limits and the global ones.
+type outboundLimiter struct {
+	limits                     *outboundLimits
+	packets, bytes             *tokenBucket
+	globalPackets, globalBytes *tokenBucket
+}
+
+// makeOutboundLimiter returns the limiter of a destination, with new buckets
+// for its own limits.
+func makeOutboundLimiter(limits *outboundLimits, now time.Time) outboundLimiter {
+	return outboundLimiter{
+		limits:        limits,
+		packets:       makeTokenBucket(limits.peerPackets, now),
+		bytes:         makeTokenBucket(limits.peerBytes, now),
+		globalPackets: limits.globalPackets,
+		globalBytes:   limits.globalBytes,
+	}
+}
+
+// delay reserves the tokens to send a packet and returns how long the sender
+// has to wait before sending it.
+func (l outboundLimiter) delay(size int, now time.Time) time.Duration {
+	return max(
+		l.packets.reserve(1, now),
+		l.bytes.reserve(float64(size), now),
+		l.globalPackets.reserve(1, now),
+		l.globalBytes.reserve(float64(size), now),
+	)
+}
+
+// An inboundLimiter limits the packets accepted from each source IP.
+//
+// At most maxTrackedSources have their own bucket: when there are more, the
+// rest share an overflow bucket, so that a flood from many, maybe spoofed,
+// source IPs can't grow the limiter without bounds.
+type inboundLimiter struct {
+	limit    RateLimit
+	mutex    sync.Mutex
+	sources  map[string]*tokenBucket
+	overflow *tokenBucket
+	pruned   time.Time
+	dropped  atomic.Uint64
+}
+
+func makeInboundLimiter(limit RateLimit) *inboundLimiter {
+	return &inboundLimiter{
+		limit:   limit,
+		sources: make(map[string]*tokenBucket),
+	}
+}
+
+// setLimit changes the limit, forgetting the tracked sources.
+func (l *inboundLimiter) setLimit(limit RateLimit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if limit != l.limit {
+		l.limit = limit
+		l.sources = make(map[string]*tokenBucket)
+		l.overflow = nil
+	}
+}
+
+// allow checks whether a packet from the source IP is within the limit,
+// counting it as dropped if it isn't.
+func (l *inboundLimiter) allow(IP net.IP) bool {
+	var (
+		now = time.Now()
+		key = peerKey(IP)
+	)
+
+	l.mutex.Lock()
+	if !l.limit.enabled() {
+		l.mutex.Unlock()
+		return true
+	}
+
+	bucket, ok := l.sources[key]
+	if !ok {
+		bucket = l.track(key, now)
+	}
+	l.mutex.Unlock()
+
+	if !bucket.allow(1, now) {
+		l.dropped.Add(1)
+		return false
+	}
+
+	return true
+}
+
+// track returns a new bucket for a source, or the overflow bucket if there's
+// no room for it.
+// The caller must hold the mutex.
+func (l *inboundLimiter) track(key string, now time.Time) *tokenBucket {
+	if len(l.sources) >= maxTrackedSources && now.Sub(l.pruned) >= pruneInterval {
+		l.prune(now)
+	}
+
+	if len(l.sources) >= maxTrackedSources {
+		if l.overflow == nil {
+			l.overflow = makeTokenBucket(l.limit, now)
+		}
+		return l.overflow
+	}
+
+	bucket := makeTokenBucket(l.limit, now)
+	l.sources[key] = bucket
+	return bucket
+}
+
+// prune removes the buckets that have refilled completely.
+// The caller must hold the mutex.
+func (l *inboundLimiter) prune(now time.Time) {
+	l.pruned = now
+	for source, bucket := range l.sources {
+		if bucket.isFull(now) {
+			delete(l.sources, source)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package prototari
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Disabled limit allows everything", func(t *testing.T) {
+		bucket := makeTokenBucket(RateLimit{}, now)
+
+		assert.Nil(t, bucket)
+		assert.True(t, bucket.allow(1000, now))
+		assert.Equal(t, time.Duration(0), bucket.reserve(1000, now))
+	})
+
+	t.Run("Allows bursts and then the rate", func(t *testing.T) {
+		bucket := makeTokenBucket(RateLimit{Rate: 10, Burst: 2}, now)
+
+		assert.True(t, bucket.allow(1, now))
+		assert.True(t, bucket.allow(1, now))
+		assert.False(t, bucket.allow(1, now))
+
+		// At 10 tokens per second, a token is added every 100ms
+		assert.True(t, bucket.allow(1, now.Add(100*time.Millisecond)))
+		assert.False(t, bucket.allow(1, now.Add(100*time.Millisecond)))
+	})
+
+	t.Run("Reserving returns the time to wait for the tokens", func(t *testing.T) {
+		bucket := makeTokenBucket(RateLimit{Rate: 10, Burst: 1}, now)
+
+		assert.Equal(t, time.Duration(0), bucket.reserve(1, now))
+		assert.Equal(t, 100*time.Millisecond, bucket.reserve(1, now))
+		assert.Equal(t, 200*time.Millisecond, bucket.reserve(1, now))
+	})
+}
+
+func TestInboundLimiter(t *testing.T) {
+	var (
+		limiter = makeInboundLimiter(RateLimit{Rate: 1, Burst: 1})
+		ipA     = net.IP([]byte("192.168.0.10"))
+		ipB     = net.IP([]byte("192.168.0.20"))
+	)
+
+	assert.True(t, limiter.allow(ipA))
+	assert.False(t, limiter.allow(ipA))
+
+	// Each source IP has its own bucket
+	assert.True(t, limiter.allow(ipB))
+
+	assert.Equal(t, uint64(1), limiter.dropped.Load())
+}
+
+func TestInboundLimits(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peerAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		readCh    = make(chan fakeMsgRecord)
+		unicConn  = fakeUnicastConn{localAddr: localAddr, readChan: readCh}
+		config    = makeTestingConfig()
+	)
+	// A single packet every 10 seconds
+	config.RateLimits.InboundPackets = RateLimit{Rate: 0.1, Burst: 1}
+
+	manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, config)
+	manager.registerPeer(MakePeer(peerAddr.IP))
+	manager.Start()
+	defer manager.Stop()
+
+	for range 2 {
+		readCh <- fakeMsgRecord{IsUnicast: true, From: peerAddr, To: localAddr, Payload: []byte("kaixo")}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := manager.Receive(ctx)
+	assert.Nil(t, err)
+
+	// The dropped packet is only counted as dropped
+	assert.Eventually(t, func() bool {
+		return manager.RateLimitStats().InboundDropped == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), manager.Metrics().Traffic["data"].PacketsIn)
+}
+
+func TestInboundLimiterFlood(t *testing.T) {
+	var (
+		limiter = makeInboundLimiter(RateLimit{Rate: 1, Burst: 1})
+		sources = maxTrackedSources + 100
+		allowed = 0
+	)
+
+	for i := range sources {
+		if limiter.allow(net.IPv4(10, 0, byte(i>>8), byte(i))) {
+			allowed++
+		}
+	}
+
+	assert.Len(t, limiter.sources, maxTrackedSources)
+	// The sources that didn't fit share a single bucket
+	assert.Equal(t, maxTrackedSources+1, allowed)
+	assert.Equal(t, uint64(sources-allowed), limiter.dropped.Load())
+}
+
+func TestOutboundLimits(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peer      = MakePeer(net.ParseIP("192.168.0.20"))
+		writeCh   = make(chan fakeMsgRecord, 16)
+		unicConn  = fakeUnicastConn{
+			localAddr: localAddr,
+			writeChan: writeCh,
+			written:   make(chan fakeMsgRecord, 16),
+		}
+		config = makeTestingConfig()
+	)
+	// A single packet every 10 seconds
+	config.RateLimits.PeerPackets = RateLimit{Rate: 0.1, Burst: 1}
+
+	manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, config)
+	manager.registerPeer(peer)
+	manager.Start()
+	defer manager.Stop()
+
+	// The second message waits for the data budget
+	assert.Nil(t, manager.SendMessageTo(context.Background(), peer, []byte("kaixo")))
+	assert.Nil(t, manager.SendMessageTo(context.Background(), peer, []byte("zer moduz?")))
+	assert.Equal(t, "kaixo", string((<-writeCh).Payload))
+
+	manager.sendControl(heartbeatMessage, peer.IP)
+	manager.sendControl(disconnectMessage, peer.IP)
+
+	for _, want := range []string{heartbeatMessage, disconnectMessage} {
+		select {
+		case msg := <-writeCh:
+			assert.Equal(t, want, string(msg.Payload))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "The control messages were held by the data limits")
+		}
+	}
+	assert.Equal(t, uint64(1), manager.RateLimitStats().OutboundDelayed)
+}
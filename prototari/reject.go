@@ -0,0 +1,201 @@
+package prototari
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protocolVersion is the version of the protocol spoken by this computer.
+// The responses and confirmations of the computers speaking another version
+// carry it after the message; those of version 1 don't carry any.
+const protocolVersion = 1
+
+// maxRejectBackoff is the longest a computer waits to handshake again with
+// another that rejected it for being full.
+const maxRejectBackoff = 5 * time.Minute
+
+var (
+	// ErrVersionMismatch is the reason a peer isn't registered when it speaks
+	// another version of the protocol.
+	ErrVersionMismatch = errors.New("protocol version mismatch")
+
+	// ErrRejectedUnknown is wrapped by the rejections for a reason this
+	// computer doesn't know.
+	ErrRejectedUnknown = errors.New("rejected for an unknown reason")
+)
+
+// A RejectReason is why a computer refused to register another, as sent in
+// the reject message.
+type RejectReason string
+
+const (
+	// RejectFull is the reason of computers with MaxPeers registered.
+	RejectFull RejectReason = "full"
+	// RejectDenied is the reason of computers whose admission filter rejects
+	// the other.
+	RejectDenied RejectReason = "denied"
+	// RejectVersion is the reason of computers speaking another version of
+	// the protocol.
+	RejectVersion RejectReason = "version"
+)
+
+// known checks whether the reason is one of those sent by this computer.
+func (r RejectReason) known() bool {
+	switch r {
+	case RejectFull, RejectDenied, RejectVersion:
+		return true
+	default:
+		return false
+	}
+}
+
+// rejectReasonOf returns the reason sent to a computer that couldn't be
+// registered because of the given error, if it's one to tell it about.
+func rejectReasonOf(err error) (RejectReason, bool) {
+	switch {
+	case errors.Is(err, ErrMaxPeers):
+		return RejectFull, true
+	case errors.Is(err, ErrNotAdmitted):
+		return RejectDenied, true
+	case errors.Is(err, ErrVersionMismatch):
+		return RejectVersion, true
+	default:
+		return "", false
+	}
+}
+
+// A RejectedError is the reason of a handshake the other computer rejected.
+// It wraps the error matching the reason: ErrMaxPeers, ErrNotAdmitted or
+// ErrVersionMismatch, or ErrRejectedUnknown for any other reason.
+type RejectedError struct {
+	Reason RejectReason
+}
+
+func (e *RejectedError) Error() string {
+	if !e.Reason.known() {
+		return fmt.Sprintf("rejected by peer: unknown reason %q", e.Reason)
+	}
+
+	return fmt.Sprintf("rejected by peer: %s", e.Reason)
+}
+
+func (e *RejectedError) Unwrap() error {
+	switch e.Reason {
+	case RejectFull:
+		return ErrMaxPeers
+	case RejectDenied:
+		return ErrNotAdmitted
+	case RejectVersion:
+		return ErrVersionMismatch
+	default:
+		return ErrRejectedUnknown
+	}
+}
+
+// checkVersion returns ErrVersionMismatch if a response or confirmation comes
+// from a computer speaking another version of the protocol.
+func checkVersion(payload []byte) error {
+	_, arg, ok := strings.Cut(string(payload), " ")
+	if !ok {
+		return nil
+	}
+
+	if version, err := strconv.Atoi(arg); err != nil || version != protocolVersion {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// reject tells a computer that it wasn't registered, and why, if the error is
+// one to tell it about.
+func (m *CommsManager) reject(peerAddr *net.UDPAddr, err error) {
+	reason, ok := rejectReasonOf(err)
+	if !ok {
+		return
+	}
+
+	m.sendControl(rejectMessage+string(reason), peerAddr.IP)
+}
+
+// handleRejection undoes the handshake with a computer that refused to
+// register this one: the broadcaster unregisters it, and the responder forgets
+// about it. If it was full, it's left alone for a while.
+//
+// It returns a *HandshakeError wrapping the *RejectedError, or nil if there
+// was no handshake with the computer.
+func (m *CommsManager) handleRejection(peerAddr *net.UDPAddr, payload []byte) error {
+	var (
+		reason = RejectReason(strings.TrimPrefix(string(payload), rejectMessage))
+		err    = &RejectedError{Reason: reason}
+		event  = HandshakeEvent{IP: peerAddr.IP, Stage: HandshakeRejected, Err: err}
+	)
+
+	m.logger.Info(
+		"Handshake rejected",
+		slog.String("peer", peerAddr.IP.String()),
+		slog.String("reason", string(reason)),
+	)
+
+	// The broadcaster registered the responder before sending the
+	// confirmation
+	if m.unregisterPeer(peerAddr.IP, EvictionRejected) {
+		event.Role = HandshakeBroadcaster
+	} else if m.forgetCandidate(peerAddr.IP) {
+		event.Role = HandshakeResponder
+	} else {
+		return nil
+	}
+
+	if reason == RejectFull {
+		m.backOff(peerAddr.IP)
+	}
+	m.observer.OnHandshake(event)
+
+	return &HandshakeError{Peer: MakePeer(peerAddr.IP), Role: event.Role, Err: err}
+}
+
+// A rejection is how long to leave alone a computer that rejected this one for
+// being full, or was replaced by another.
+type rejection struct {
+	until time.Time
+	wait  time.Duration
+}
+
+// backOff leaves a computer that rejected this one for being full, or was
+// replaced by another, alone for a while: twice as long as the previous time,
+// starting at the broadcast interval.
+func (m *CommsManager) backOff(IP net.IP) {
+	interval := m.Config().BroadcastInterval
+
+	m.peersMutex.Lock()
+	defer m.peersMutex.Unlock()
+
+	wait := min(2*m.rejections[peerKey(IP)].wait, maxRejectBackoff)
+	wait = max(wait, interval)
+	m.rejections[peerKey(IP)] = rejection{until: time.Now().Add(wait), wait: wait}
+}
+
+// backingOff returns whether a computer is being left alone.
+func (m *CommsManager) backingOff(IP net.IP) bool {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	return time.Now().Before(m.rejections[peerKey(IP)].until)
+}
+
+// forgetRejections discards the rejections that ended long enough ago to
+// start over the backoff.
+// The caller must hold the peers mutex.
+func (m *CommsManager) forgetRejections(now time.Time) {
+	for key, rejection := range m.rejections {
+		if now.Sub(rejection.until) > maxRejectBackoff {
+			delete(m.rejections, key)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReject(t *testing.T) {
+	var (
+		localAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		peerAddr    = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		otherAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.30"), Port: UnicastPort}
+		makeManager = func() (*CommsManager, chan<- fakeMsgRecord, <-chan fakeMsgRecord) {
+			var (
+				readCh   = make(chan fakeMsgRecord)
+				writeCh  = make(chan fakeMsgRecord, 16)
+				unicConn = fakeUnicastConn{
+					localAddr: localAddr,
+					readChan:  readCh,
+					writeChan: writeCh,
+					written:   make(chan fakeMsgRecord, 16),
+				}
+			)
+
+			return MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, makeTestingConfig()), readCh, writeCh
+		}
+		receive = func(readCh chan<- fakeMsgRecord, from *net.UDPAddr, payload string) {
+			readCh <- fakeMsgRecord{IsUnicast: true, From: from, To: localAddr, Payload: []byte(payload)}
+		}
+		nOfCandidates = func(manager *CommsManager) int {
+			manager.peersMutex.RLock()
+			defer manager.peersMutex.RUnlock()
+			return len(manager.candidates)
+		}
+		written = func(t *testing.T, writeCh <-chan fakeMsgRecord) fakeMsgRecord {
+			select {
+			case msg := <-writeCh:
+				return msg
+			case <-time.After(time.Second):
+				assert.FailNow(t, "Nothing was sent")
+				return fakeMsgRecord{}
+			}
+		}
+	)
+
+	t.Run("Full broadcasters reject the responses", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		// The testing configuration allows a single peer
+		manager.registerPeer(MakePeer(peerAddr.IP))
+		manager.Start()
+		defer manager.Stop()
+
+		receive(readCh, otherAddr, responseMessage)
+
+		msg := written(t, writeCh)
+		assert.Equal(t, rejectMessage+string(RejectFull), string(msg.Payload))
+		assert.True(t, otherAddr.IP.Equal(msg.To.IP))
+	})
+
+	t.Run("Full responders reject the confirmations", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		manager.registerPeer(MakePeer(peerAddr.IP))
+		manager.advanceCandidate(otherAddr.IP, PeerHandshakePending)
+		manager.Start()
+		defer manager.Stop()
+
+		receive(readCh, otherAddr, confirmationMessage)
+
+		msg := written(t, writeCh)
+		assert.Equal(t, rejectMessage+string(RejectFull), string(msg.Payload))
+		assert.Zero(t, nOfCandidates(manager))
+	})
+
+	t.Run("Computers speaking another version are rejected", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		manager.Start()
+		defer manager.Stop()
+
+		receive(readCh, otherAddr, responseMessage+" 2")
+
+		msg := written(t, writeCh)
+		assert.Equal(t, rejectMessage+string(RejectVersion), string(msg.Payload))
+		assert.False(t, manager.hasPeer(otherAddr.IP))
+	})
+
+	t.Run("Broadcasters unregister the responders that reject them", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		manager.Start()
+		defer manager.Stop()
+
+		receive(readCh, peerAddr, responseMessage)
+		assert.Equal(t, confirmationMessage, string(written(t, writeCh).Payload))
+
+		receive(readCh, peerAddr, rejectMessage+string(RejectFull))
+
+		select {
+		case err := <-manager.Errors():
+			var rejectedErr *RejectedError
+			assert.ErrorAs(t, err, &rejectedErr)
+			assert.Equal(t, RejectFull, rejectedErr.Reason)
+			assert.ErrorIs(t, err, ErrMaxPeers)
+		case <-time.After(time.Second):
+			assert.FailNow(t, "No error was reported")
+		}
+		assert.False(t, manager.hasPeer(peerAddr.IP))
+
+		// Its responses are ignored for a while
+		receive(readCh, peerAddr, responseMessage)
+		assert.False(t, manager.hasPeer(peerAddr.IP))
+		assert.Empty(t, writeCh)
+	})
+
+	t.Run("Responders back off from the broadcasters that reject them for being full", func(t *testing.T) {
+		manager, readCh, _ := makeManager()
+		manager.advanceCandidate(peerAddr.IP, PeerHandshakePending)
+		manager.Start()
+		defer manager.Stop()
+
+		receive(readCh, peerAddr, rejectMessage+string(RejectFull))
+		<-manager.Errors()
+
+		assert.Zero(t, nOfCandidates(manager))
+		assert.False(t, manager.shouldRespond(peerAddr.IP))
+	})
+
+	t.Run("Unsolicited rejections are ignored", func(t *testing.T) {
+		manager, _, _ := makeManager()
+
+		assert.Nil(t, manager.handleRejection(peerAddr, []byte(rejectMessage+string(RejectFull))))
+		assert.False(t, manager.backingOff(peerAddr.IP))
+	})
+
+	t.Run("Rejections for unknown reasons keep the reason", func(t *testing.T) {
+		err := &RejectedError{Reason: "busy"}
+
+		assert.ErrorIs(t, err, ErrRejectedUnknown)
+		assert.ErrorContains(t, err, `"busy"`)
+		assert.ErrorIs(t, &RejectedError{Reason: RejectFull}, ErrMaxPeers)
+	})
+
+	t.Run("The backoff doubles up to a maximum", func(t *testing.T) {
+		var (
+			manager, _, _ = makeManager()
+			interval      = time.Second
+		)
+		manager.config.BroadcastInterval = interval
+
+		manager.backOff(peerAddr.IP)
+		assert.Equal(t, interval, manager.rejections[peerKey(peerAddr.IP)].wait)
+
+		manager.backOff(peerAddr.IP)
+		assert.Equal(t, 2*interval, manager.rejections[peerKey(peerAddr.IP)].wait)
+
+		for range 20 {
+			manager.backOff(peerAddr.IP)
+		}
+		assert.Equal(t, maxRejectBackoff, manager.rejections[peerKey(peerAddr.IP)].wait)
+
+		manager.registerPeer(MakePeer(peerAddr.IP))
+		assert.False(t, manager.backingOff(peerAddr.IP))
+	})
+}
@@ -0,0 +1,304 @@
+package prototari
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when sending a message to a peer whose send
+	// queue is full and the overflow policy is OverflowError.
+	ErrQueueFull = errors.New("send queue full")
+
+	// ErrQueueClosed is returned when sending a message to a peer whose send
+	// queue no longer accepts messages, because the communications stopped.
+	ErrQueueClosed = errors.New("send queue closed")
+
+	// errQueueRetired is returned when pushing into a queue that was removed
+	// for being idle. A new queue has to be created for the destination.
+	errQueueRetired = errors.New("send queue retired")
+)
+
+// A Priority is the class of an outgoing message.
+// The queued control messages are sent before the queued data messages.
+type Priority int
+
+const (
+	// PriorityControl is the class of the protocol messages, like "dale!".
+	PriorityControl Priority = iota
+	// PriorityData is the class of the application messages.
+	PriorityData
+
+	nOfPriorities = int(PriorityData) + 1
+)
+
+// An OverflowPolicy decides what happens when a message is sent to a peer
+// whose send queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there's space in the queue or the
+	// context is done.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued message to make room for
+	// the new one.
+	OverflowDropOldest
+	// OverflowDropNewest discards the new message.
+	OverflowDropNewest
+	// OverflowError discards the new message and returns ErrQueueFull.
+	OverflowError
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the policy as its name, like "drop-oldest".
+func (p OverflowPolicy) MarshalText() ([]byte, error) {
+	if p < OverflowBlock || p > OverflowError {
+		return nil, fmt.Errorf("unknown overflow policy %d", int(p))
+	}
+
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy from its name, like "drop-oldest".
+func (p *OverflowPolicy) UnmarshalText(text []byte) error {
+	for policy := OverflowBlock; policy <= OverflowError; policy++ {
+		if string(text) == policy.String() {
+			*p = policy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown overflow policy %q", text)
+}
+
+// An outgoingMessage is a message waiting in a send queue.
+type outgoingMessage struct {
+	payload  []byte
+	to       *net.UDPAddr
+	priority Priority
+	// stamp, if set, returns the payload to write given the time right before
+	// writing it, for the messages carrying the time they're sent, which
+	// mustn't include the wait in the queue.
+	stamp func(payload []byte, now time.Time) []byte
+}
+
+// A sendQueue is a bounded queue of outgoing messages to a single destination.
+// Each priority class has its own capacity, so a flood of data messages never
+// pushes the control messages out.
+type sendQueue struct {
+	mutex     sync.Mutex
+	classes   [nOfPriorities][]outgoingMessage
+	capacity  int
+	closed    bool
+	discarded bool
+	retired   bool
+
+	// ready signals the sender that a message was pushed.
+	ready chan struct{}
+	// space signals the blocked pushers that a message was popped.
+	space chan struct{}
+	// done is closed when the queue is closed or retired.
+	done chan struct{}
+}
+
+func makeSendQueue(capacity int) *sendQueue {
+	return &sendQueue{
+		capacity: capacity,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// push adds a message to the queue, applying the overflow policy if the
+// message's priority class is full.
+func (q *sendQueue) push(
+	ctx context.Context,
+	message outgoingMessage,
+	policy OverflowPolicy,
+) error {
+	for {
+		q.mutex.Lock()
+
+		switch {
+		case q.retired:
+			q.mutex.Unlock()
+			return errQueueRetired
+		case q.closed:
+			q.mutex.Unlock()
+			return ErrQueueClosed
+		}
+
+		class := q.classes[message.priority]
+		if len(class) < q.capacity {
+			q.classes[message.priority] = append(class, message)
+			hasSpace := len(class)+1 < q.capacity
+			q.mutex.Unlock()
+
+			signal(q.ready)
+			// Pass the wake up on to the next blocked pusher
+			if hasSpace {
+				signal(q.space)
+			}
+
+			return nil
+		}
+
+		switch policy {
+		case OverflowDropOldest:
+			q.classes[message.priority] = append(class[1:], message)
+			q.mutex.Unlock()
+			return nil
+		case OverflowDropNewest:
+			q.mutex.Unlock()
+			return nil
+		case OverflowError:
+			q.mutex.Unlock()
+			return ErrQueueFull
+		}
+
+		q.mutex.Unlock()
+
+		select {
+		case <-q.space:
+		case <-q.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pop removes the highest priority message from the queue.
+// It blocks until there's a message, returning false if the queue is closed
+// and empty, or if no message is pushed in the idle time.
+func (q *sendQueue) pop(idle time.Duration) (outgoingMessage, bool) {
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+
+	for {
+		q.mutex.Lock()
+
+		for priority, class := range q.classes {
+			if len(class) > 0 {
+				message := class[0]
+				q.classes[priority] = class[1:]
+				q.mutex.Unlock()
+
+				signal(q.space)
+				return message, true
+			}
+		}
+
+		if q.closed || q.retired {
+			q.mutex.Unlock()
+			return outgoingMessage{}, false
+		}
+
+		q.mutex.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-q.done:
+		case <-timer.C:
+			return outgoingMessage{}, false
+		}
+	}
+}
+
+// popControl removes the oldest queued control message, if any, without
+// blocking.
+func (q *sendQueue) popControl() (outgoingMessage, bool) {
+	q.mutex.Lock()
+
+	class := q.classes[PriorityControl]
+	if len(class) == 0 {
+		q.mutex.Unlock()
+		return outgoingMessage{}, false
+	}
+
+	message := class[0]
+	q.classes[PriorityControl] = class[1:]
+	q.mutex.Unlock()
+
+	signal(q.space)
+	return message, true
+}
+
+// close stops the queue from accepting messages. The queued messages are still
+// popped, unless discard is true, in which case they're dropped.
+func (q *sendQueue) close(discard bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if discard {
+		for priority := range q.classes {
+			q.classes[priority] = nil
+		}
+		q.discarded = true
+	}
+
+	q.finish()
+	q.closed = true
+}
+
+// isDiscarded checks whether the queue was closed discarding its messages.
+func (q *sendQueue) isDiscarded() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.discarded
+}
+
+// retireIfEmpty marks an empty queue as retired, so that it no longer accepts
+// messages. It returns whether the queue was retired.
+func (q *sendQueue) retireIfEmpty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, class := range q.classes {
+		if len(class) > 0 {
+			return false
+		}
+	}
+
+	q.finish()
+	q.retired = true
+
+	return true
+}
+
+// finish wakes up both the sender and every blocked pusher, which then find
+// out that the queue is closed or retired.
+// The caller must hold the mutex.
+func (q *sendQueue) finish() {
+	if !q.closed && !q.retired {
+		close(q.done)
+	}
+}
+
+// signal does a non-blocking send on a channel with a capacity of one, so
+// that consecutive signals coalesce into a single one.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,106 @@
+package prototari
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendQueue(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		control = func(payload string) outgoingMessage {
+			return outgoingMessage{payload: []byte(payload), priority: PriorityControl}
+		}
+		data = func(payload string) outgoingMessage {
+			return outgoingMessage{payload: []byte(payload), priority: PriorityData}
+		}
+		popPayload = func(q *sendQueue) string {
+			message, ok := q.pop(10 * time.Millisecond)
+			if !ok {
+				return ""
+			}
+			return string(message.payload)
+		}
+	)
+
+	t.Run("Control messages are popped before data messages", func(t *testing.T) {
+		q := makeSendQueue(2)
+
+		q.push(ctx, data("data"), OverflowError)
+		q.push(ctx, control("dale!"), OverflowError)
+
+		assert.Equal(t, "dale!", popPayload(q))
+		assert.Equal(t, "data", popPayload(q))
+		assert.Equal(t, "", popPayload(q))
+	})
+
+	t.Run("Each priority class has its own capacity", func(t *testing.T) {
+		q := makeSendQueue(1)
+
+		assert.Nil(t, q.push(ctx, data("data"), OverflowError))
+		assert.Nil(t, q.push(ctx, control("dale!"), OverflowError))
+	})
+
+	t.Run("Overflow policies", func(t *testing.T) {
+		q := makeSendQueue(1)
+		q.push(ctx, data("first"), OverflowError)
+
+		assert.ErrorIs(t, q.push(ctx, data("second"), OverflowError), ErrQueueFull)
+		assert.Nil(t, q.push(ctx, data("second"), OverflowDropNewest))
+		assert.Equal(t, "first", popPayload(q))
+
+		q.push(ctx, data("first"), OverflowError)
+		assert.Nil(t, q.push(ctx, data("second"), OverflowDropOldest))
+		assert.Equal(t, "second", popPayload(q))
+	})
+
+	t.Run("Blocking push waits for room in the queue", func(t *testing.T) {
+		var (
+			q      = makeSendQueue(1)
+			pushed = make(chan error)
+		)
+		q.push(ctx, data("first"), OverflowBlock)
+
+		go func() {
+			pushed <- q.push(ctx, data("second"), OverflowBlock)
+		}()
+
+		select {
+		case <-pushed:
+			assert.FailNow(t, "Push didn't block")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		assert.Equal(t, "first", popPayload(q))
+		assert.Nil(t, <-pushed)
+		assert.Equal(t, "second", popPayload(q))
+	})
+
+	t.Run("Blocking push returns the context error", func(t *testing.T) {
+		q := makeSendQueue(1)
+		q.push(ctx, data("first"), OverflowBlock)
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, q.push(ctx, data("second"), OverflowBlock), context.DeadlineExceeded)
+	})
+
+	t.Run("Closed queue is drained unless discarded", func(t *testing.T) {
+		q := makeSendQueue(2)
+		q.push(ctx, data("first"), OverflowError)
+		q.close(false)
+
+		assert.ErrorIs(t, q.push(ctx, data("second"), OverflowError), ErrQueueClosed)
+		assert.Equal(t, "first", popPayload(q))
+
+		q = makeSendQueue(2)
+		q.push(ctx, data("first"), OverflowError)
+		q.close(true)
+
+		assert.Equal(t, "", popPayload(q))
+	})
+}
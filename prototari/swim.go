@@ -0,0 +1,290 @@
+package prototari
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxGossipUpdates is the number of membership updates kept to be
+	// piggybacked on the probes. Past it, the oldest ones are dropped.
+	maxGossipUpdates = 32
+
+	// maxPiggybackedUpdates is the number of membership updates piggybacked on
+	// a single probe.
+	maxPiggybackedUpdates = 6
+
+	// gossipTransmissions is the number of probes each membership update is
+	// piggybacked on.
+	gossipTransmissions = 3
+)
+
+// A membershipUpdate is the news of a peer's state change that's spread by
+// piggybacking it on the probe traffic.
+type membershipUpdate struct {
+	IP    net.IP
+	State PeerState
+	// transmissions is the number of probes the update was piggybacked on.
+	transmissions int
+}
+
+// gossip keeps the membership updates waiting to be piggybacked on probes.
+type gossip struct {
+	mutex   sync.Mutex
+	updates []membershipUpdate
+}
+
+// record adds an update, replacing the previous one about the same peer.
+func (g *gossip) record(IP net.IP, state PeerState) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.updates = slices.DeleteFunc(g.updates, func(update membershipUpdate) bool {
+		return update.IP.Equal(IP)
+	})
+	if len(g.updates) >= maxGossipUpdates {
+		g.updates = g.updates[1:]
+	}
+	g.updates = append(g.updates, membershipUpdate{IP: IP, State: state})
+}
+
+// piggyback returns the encoded updates to append to a probe, forgetting those
+// transmitted enough times.
+func (g *gossip) piggyback() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var (
+		n       = min(len(g.updates), maxPiggybackedUpdates)
+		encoded strings.Builder
+	)
+	for i := range n {
+		update := &g.updates[i]
+		update.transmissions++
+
+		encoded.WriteString("\n")
+		encoded.WriteString(update.State.String())
+		encoded.WriteString(" ")
+		encoded.WriteString(update.IP.String())
+	}
+
+	// The transmitted updates go to the back, so the rest get their turn in
+	// the next probes
+	g.updates = slices.Concat(g.updates[n:], g.updates[:n])
+	g.updates = slices.DeleteFunc(g.updates, func(update membershipUpdate) bool {
+		return update.transmissions >= gossipTransmissions
+	})
+
+	return encoded.String()
+}
+
+// parseUpdates decodes the membership updates piggybacked on a probe, ignoring
+// the malformed ones.
+func parseUpdates(payload []byte) []membershipUpdate {
+	_, rest, _ := bytes.Cut(payload, []byte("\n"))
+
+	var updates []membershipUpdate
+	for _, line := range strings.Split(string(rest), "\n") {
+		if update, ok := parseUpdate(line); ok {
+			updates = append(updates, update)
+		}
+	}
+
+	return updates
+}
+
+// parseUpdate decodes a membership update, returning false if it's malformed.
+func parseUpdate(line string) (membershipUpdate, bool) {
+	stateName, ip, ok := strings.Cut(line, " ")
+	if !ok {
+		return membershipUpdate{}, false
+	}
+
+	var update membershipUpdate
+	if update.IP = net.ParseIP(ip); update.IP == nil {
+		return membershipUpdate{}, false
+	}
+	if err := update.State.UnmarshalText([]byte(stateName)); err != nil {
+		return membershipUpdate{}, false
+	}
+
+	return update, true
+}
+
+// wellFormedUpdates checks whether every line of the text following a probe's
+// first line is a membership update.
+func wellFormedUpdates(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if _, ok := parseUpdate(line); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// probeHeader returns the first line of a probe, which carries the message
+// type and its argument, without the piggybacked updates.
+func probeHeader(payload []byte) string {
+	header, _, _ := bytes.Cut(payload, []byte("\n"))
+	return string(header)
+}
+
+// A probeRelay is an indirect probe requested by a peer, waiting for the
+// target to answer.
+type probeRelay struct {
+	requester net.IP
+	since     time.Time
+}
+
+// sendProbe queues a probe to the computer with the given IP, piggybacking the
+// membership updates if the indirect probes are enabled.
+func (m *CommsManager) sendProbe(message string, IP net.IP) {
+	m.sendStampedProbe(message, IP, nil)
+}
+
+// sendStampedProbe queues a probe like sendProbe, with the function stamping
+// it right before it's written.
+func (m *CommsManager) sendStampedProbe(message string, IP net.IP, stamp func(payload []byte, now time.Time) []byte) {
+	if m.Config().IndirectProbes > 0 {
+		message += m.gossip.piggyback()
+	}
+
+	m.sendStampedControl(message, IP, stamp)
+}
+
+// probeHelpers picks up to n random registered peers, other than the target,
+// to probe the target indirectly. The connected peers are preferred over the
+// suspect ones.
+// The caller must hold the peers mutex.
+func (m *CommsManager) probeHelpers(target net.IP, n int) []net.IP {
+	var connected, suspect []net.IP
+
+	for _, peer := range m.peers {
+		switch {
+		case peer.IP.Equal(target):
+		case peer.State == PeerSuspect:
+			suspect = append(suspect, peer.IP)
+		default:
+			connected = append(connected, peer.IP)
+		}
+	}
+
+	rand.Shuffle(len(connected), func(i, j int) { connected[i], connected[j] = connected[j], connected[i] })
+	rand.Shuffle(len(suspect), func(i, j int) { suspect[i], suspect[j] = suspect[j], suspect[i] })
+
+	helpers := append(connected, suspect...)
+	return helpers[:min(n, len(helpers))]
+}
+
+// relayProbe probes a peer on behalf of the requester, which didn't get an
+// answer from it. The answer is relayed when the target is heard from; if it
+// isn't a registered peer, the request is ignored.
+func (m *CommsManager) relayProbe(requester net.IP, target net.IP) {
+	var (
+		key = peerKey(target)
+		now = time.Now()
+	)
+
+	m.peersMutex.Lock()
+
+	peer, ok := m.peers[key]
+	if !ok || peer.IP.Equal(requester) {
+		m.peersMutex.Unlock()
+		return
+	}
+
+	m.relays[key] = append(m.relays[key], probeRelay{requester: requester, since: now})
+
+	// A heartbeat already waiting for an answer serves the request too
+	probe := peer.heartbeatSent.IsZero()
+	if probe {
+		peer.heartbeatSent = now
+		m.peers[key] = peer
+	}
+	m.peersMutex.Unlock()
+
+	if probe {
+		m.sendHeartbeat(target, nil)
+	}
+}
+
+// takeRelays removes the indirect probes waiting for the target, returning
+// their requesters.
+// The caller must hold the peers mutex.
+func (m *CommsManager) takeRelays(target net.IP) []net.IP {
+	key := peerKey(target)
+
+	var requesters []net.IP
+	for _, relay := range m.relays[key] {
+		requesters = append(requesters, relay.requester)
+	}
+	delete(m.relays, key)
+
+	return requesters
+}
+
+// expireRelays forgets the indirect probes requested before the given time.
+// The caller must hold the peers mutex.
+func (m *CommsManager) expireRelays(since time.Time) {
+	for key, relays := range m.relays {
+		relays = slices.DeleteFunc(relays, func(relay probeRelay) bool {
+			return relay.since.Before(since)
+		})
+
+		if len(relays) == 0 {
+			delete(m.relays, key)
+		} else {
+			m.relays[key] = relays
+		}
+	}
+}
+
+// applyUpdates acts on the membership updates piggybacked on a probe from a
+// registered peer. Other computers' word isn't enough to evict a peer, so the
+// peers reported as suspect or disconnected are probed right away. Reports
+// about this computer are refuted answering the sender, unless the probe was
+// already answered.
+func (m *CommsManager) applyUpdates(from net.IP, payload []byte, answered bool) {
+	var (
+		myIP   = m.unicaster.LocalAddr().IP
+		now    = time.Now()
+		probe  []net.IP
+		refute bool
+	)
+
+	m.peersMutex.Lock()
+	for _, update := range parseUpdates(payload) {
+		if update.State != PeerSuspect && update.State != PeerDisconnected {
+			continue
+		}
+
+		if update.IP.Equal(myIP) {
+			refute = true
+			continue
+		}
+
+		key := peerKey(update.IP)
+		peer, ok := m.peers[key]
+		if !ok || !peer.heartbeatSent.IsZero() || peer.IP.Equal(from) {
+			continue
+		}
+
+		peer.heartbeatSent = now
+		m.peers[key] = peer
+		probe = append(probe, peer.IP)
+	}
+	m.peersMutex.Unlock()
+
+	if refute && !answered {
+		m.sendProbe(heartbeatReplyMessage, from)
+	}
+	for _, IP := range probe {
+		m.sendHeartbeat(IP, nil)
+	}
+}
@@ -0,0 +1,194 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndirectProbes(t *testing.T) {
+	var (
+		localAddr   = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		targetAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.20"), Port: UnicastPort}
+		helperAddr  = &net.UDPAddr{IP: net.ParseIP("192.168.0.21"), Port: UnicastPort}
+		makeManager = func() (*CommsManager, chan<- fakeMsgRecord, <-chan fakeMsgRecord) {
+			var (
+				readCh   = make(chan fakeMsgRecord)
+				writeCh  = make(chan fakeMsgRecord, 16)
+				unicConn = fakeUnicastConn{
+					localAddr: localAddr,
+					readChan:  readCh,
+					writeChan: writeCh,
+					written:   make(chan fakeMsgRecord, 16),
+				}
+				config = makeTestingConfig()
+			)
+			config.MaxPeers = 2
+			config.InactivePeerTime = time.Minute
+			config.HeartbeatMaxWait = time.Minute
+			config.IndirectProbes = 1
+
+			manager := MakeManager(&fakeBroadcastConn{localAddr: localAddr}, &unicConn, config)
+			return manager, readCh, writeCh
+		}
+		// registerPeers registers the target, and the helper, which won't be
+		// sent heartbeats during the test
+		registerPeers = func(manager *CommsManager) time.Time {
+			var (
+				target = MakePeer(targetAddr.IP)
+				helper = MakePeer(helperAddr.IP)
+			)
+			helper.LastSeen = target.LastSeen.Add(time.Hour)
+
+			manager.registerPeer(target)
+			manager.registerPeer(helper)
+
+			return target.LastSeen
+		}
+		expectWrite = func(t *testing.T, writeCh <-chan fakeMsgRecord, to net.IP, payload string) {
+			select {
+			case msg := <-writeCh:
+				assert.True(t, to.Equal(msg.To.IP), "sent to %s", msg.To.IP)
+				assert.Equal(t, payload, string(msg.Payload))
+			case <-time.After(time.Second):
+				assert.FailNow(t, "Message not sent", payload)
+			}
+		}
+	)
+
+	t.Run("Unanswered heartbeats are probed through other peers before suspecting", func(t *testing.T) {
+		manager, _, writeCh := makeManager()
+		now := registerPeers(manager)
+
+		manager.Start()
+		defer manager.Stop()
+
+		manager.checkHeartbeats(now.Add(time.Minute))
+		expectWrite(t, writeCh, targetAddr.IP, heartbeatMessage+" 1")
+
+		manager.checkHeartbeats(now.Add(2 * time.Minute))
+		expectWrite(t, writeCh, helperAddr.IP, indirectProbeMessage+targetAddr.IP.String())
+
+		target, _ := manager.Peer(targetAddr.IP)
+		assert.Equal(t, PeerConnected, target.State)
+		assert.Equal(t, 0, target.MissedHeartbeats)
+
+		// Nobody answered, so the suspicion is piggybacked on the next probe
+		manager.checkHeartbeats(now.Add(3 * time.Minute))
+		target, _ = manager.Peer(targetAddr.IP)
+		assert.Equal(t, PeerSuspect, target.State)
+		assert.Equal(t, 1, target.MissedHeartbeats)
+
+		manager.checkHeartbeats(now.Add(3 * time.Minute))
+		expectWrite(t, writeCh, targetAddr.IP, heartbeatMessage+" 2\nsuspect 192.168.0.20")
+	})
+
+	t.Run("An answer through another peer proves the peer is up", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		now := registerPeers(manager)
+
+		manager.Start()
+		defer manager.Stop()
+
+		manager.checkHeartbeats(now.Add(time.Minute))
+		expectWrite(t, writeCh, targetAddr.IP, heartbeatMessage+" 1")
+		manager.checkHeartbeats(now.Add(2 * time.Minute))
+		expectWrite(t, writeCh, helperAddr.IP, indirectProbeMessage+targetAddr.IP.String())
+
+		readCh <- fakeMsgRecord{
+			IsUnicast: true,
+			From:      helperAddr,
+			To:        localAddr,
+			Payload:   []byte(indirectProbeReplyMessage + targetAddr.IP.String()),
+		}
+
+		assert.Eventually(t, func() bool {
+			target, _ := manager.Peer(targetAddr.IP)
+			return target.indirectProbeSent.IsZero()
+		}, time.Second, 10*time.Millisecond)
+
+		manager.checkHeartbeats(now.Add(3 * time.Minute))
+		target, _ := manager.Peer(targetAddr.IP)
+		assert.Equal(t, PeerConnected, target.State)
+		assert.Equal(t, 0, target.MissedHeartbeats)
+	})
+
+	t.Run("Indirect probes are relayed to the target and answered", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		registerPeers(manager)
+
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{
+			IsUnicast: true,
+			From:      helperAddr,
+			To:        localAddr,
+			Payload:   []byte(indirectProbeMessage + targetAddr.IP.String()),
+		}
+		expectWrite(t, writeCh, targetAddr.IP, heartbeatMessage+" 1")
+
+		readCh <- fakeMsgRecord{IsUnicast: true, From: targetAddr, To: localAddr, Payload: []byte(heartbeatReplyMessage)}
+		expectWrite(t, writeCh, helperAddr.IP, indirectProbeReplyMessage+targetAddr.IP.String())
+	})
+
+	t.Run("Piggybacked suspicions are checked and refuted", func(t *testing.T) {
+		manager, readCh, writeCh := makeManager()
+		registerPeers(manager)
+
+		manager.Start()
+		defer manager.Stop()
+
+		readCh <- fakeMsgRecord{
+			IsUnicast: true,
+			From:      helperAddr,
+			To:        localAddr,
+			Payload:   []byte(heartbeatReplyMessage + "\nsuspect 192.168.0.20\ndisconnected 192.168.0.10"),
+		}
+
+		// The destinations have their own send queues, so the order varies
+		var sent []string
+		for range 2 {
+			select {
+			case msg := <-writeCh:
+				sent = append(sent, msg.To.IP.String()+" "+string(msg.Payload))
+			case <-time.After(time.Second):
+				assert.FailNow(t, "Message not sent")
+			}
+		}
+		assert.ElementsMatch(t, []string{
+			"192.168.0.21 " + heartbeatReplyMessage,
+			"192.168.0.20 " + heartbeatMessage + " 1",
+		}, sent)
+	})
+}
+
+func TestGossip(t *testing.T) {
+	t.Run("Updates are piggybacked a limited number of times", func(t *testing.T) {
+		var g gossip
+		g.record(net.ParseIP("192.168.0.20"), PeerSuspect)
+
+		for range gossipTransmissions {
+			assert.Equal(t, "\nsuspect 192.168.0.20", g.piggyback())
+		}
+		assert.Equal(t, "", g.piggyback())
+	})
+
+	t.Run("A new update replaces the previous one about the peer", func(t *testing.T) {
+		var g gossip
+		g.record(net.ParseIP("192.168.0.20"), PeerSuspect)
+		g.record(net.ParseIP("192.168.0.20"), PeerDisconnected)
+
+		assert.Equal(t, "\ndisconnected 192.168.0.20", g.piggyback())
+	})
+
+	t.Run("Malformed updates are ignored", func(t *testing.T) {
+		updates := parseUpdates([]byte("hor?\nsuspect 192.168.0.20\nsuspect\nsleepy 192.168.0.21\nsuspect nowhere"))
+
+		assert.Len(t, updates, 1)
+		assert.True(t, net.ParseIP("192.168.0.20").Equal(updates[0].IP))
+		assert.Equal(t, PeerSuspect, updates[0].State)
+	})
+}
@@ -3,13 +3,12 @@ package prototari
 import (
 	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
-const (
-	fakeBroadcastAddr = "192.168.0.255"
-	failReadTimeout   = 100 * time.Millisecond
-)
+const fakeBroadcastAddr = "192.168.0.255"
 
 type fakeMsgRecord struct {
 	IsUnicast bool
@@ -18,7 +17,77 @@ type fakeMsgRecord struct {
 	Payload   []byte
 }
 
+// fakeReadDeadline emulates the read deadline of a connection: setting it in
+// the past interrupts the pending reads.
+// Only past and zero deadlines are supported, as those are what the
+// CommsManager uses to interrupt and resume reading.
+type fakeReadDeadline struct {
+	mutex     sync.Mutex
+	interrupt chan struct{}
+}
+
+func (fd *fakeReadDeadline) SetReadDeadline(t time.Time) error {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+
+	if fd.interrupt == nil {
+		fd.interrupt = make(chan struct{})
+	}
+
+	if t.IsZero() {
+		select {
+		case <-fd.interrupt:
+			fd.interrupt = make(chan struct{})
+		default:
+		}
+	} else if !t.After(time.Now()) {
+		select {
+		case <-fd.interrupt:
+		default:
+			close(fd.interrupt)
+		}
+	}
+
+	return nil
+}
+
+// interrupted returns a channel that's closed when the read deadline is
+// reached.
+func (fd *fakeReadDeadline) interrupted() <-chan struct{} {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+
+	if fd.interrupt == nil {
+		fd.interrupt = make(chan struct{})
+	}
+
+	return fd.interrupt
+}
+
+// read blocks until a message is received in the channel or the read deadline
+// is reached. A nil channel blocks until the deadline.
+func (fd *fakeReadDeadline) read(
+	readChan <-chan fakeMsgRecord,
+	b []byte,
+) (int, *net.UDPAddr, error) {
+	select {
+	case message, ok := <-readChan:
+		// The goroutine might get stuck here waiting for a new message to be
+		// sent to the channel. We want to finish gracefully when the read
+		// channel is closed, and so the ok is handled to return an error.
+		if !ok {
+			return 0, nil, io.EOF
+		}
+		n := copy(b, message.Payload)
+		return n, message.From, nil
+	case <-fd.interrupted():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
 type fakeBroadcastConn struct {
+	fakeReadDeadline
+
 	writeChan chan<- fakeMsgRecord
 	readChan  <-chan fakeMsgRecord
 
@@ -52,20 +121,7 @@ func (fb *fakeBroadcastConn) Write(b []byte) (int, error) {
 }
 
 func (fb *fakeBroadcastConn) Read(b []byte) (int, *net.UDPAddr, error) {
-	if fb.readChan == nil {
-		time.Sleep(failReadTimeout)
-		return 0, nil, io.EOF
-	}
-
-	// The goroutine might get stuck here waiting for a new message to be sent
-	// to the channel. We want to finish gracefully when the read channel is
-	// closed, and so the ok is handled to return an error.
-	message, ok := <-fb.readChan
-	if !ok {
-		return 0, nil, io.EOF
-	}
-	n := copy(b, message.Payload)
-	return n, message.From, nil
+	return fb.read(fb.readChan, b)
 }
 
 func (fu *fakeBroadcastConn) Close() {
@@ -74,6 +130,8 @@ func (fu *fakeBroadcastConn) Close() {
 }
 
 type fakeUnicastConn struct {
+	fakeReadDeadline
+
 	writeChan chan<- fakeMsgRecord
 	readChan  <-chan fakeMsgRecord
 
@@ -104,20 +162,7 @@ func (fu *fakeUnicastConn) Write(b []byte, to *net.UDPAddr) (int, error) {
 }
 
 func (fu *fakeUnicastConn) Read(b []byte) (int, *net.UDPAddr, error) {
-	if fu.readChan == nil {
-		time.Sleep(failReadTimeout)
-		return 0, nil, io.EOF
-	}
-
-	// The goroutine might get stuck here waiting for a new message to be sent
-	// to the channel. We want to finish gracefully when the read channel is
-	// closed, and so the ok is handled to return an error.
-	message, ok := <-fu.readChan
-	if !ok {
-		return 0, nil, io.EOF
-	}
-	n := copy(b, message.Payload)
-	return n, message.From, nil
+	return fu.read(fu.readChan, b)
 }
 
 func (fu *fakeUnicastConn) Close() {
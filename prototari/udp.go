@@ -2,7 +2,6 @@ package prototari
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"time"
 )
@@ -10,6 +9,10 @@ import (
 // UDPBroadcastConn is an implementation of the BroadcastConn interface that uses
 // the UDP connection-less protocol to send and receive messages.
 type UDPBroadcastConn struct {
+	// Port is the port the broadcast messages are sent to and read from.
+	// Zero means BroadcastPort.
+	Port int
+
 	localAddr     *net.UDPAddr
 	broadcastAddr *net.UDPAddr
 
@@ -19,54 +22,54 @@ type UDPBroadcastConn struct {
 	readConn *net.UDPConn
 }
 
-func (conn *UDPBroadcastConn) Connect() {
+// Connect opens the connections to send and receive broadcast messages in the
+// private network. The protocol can't work without them, so the error should
+// be treated as fatal.
+func (conn *UDPBroadcastConn) Connect() error {
 	if conn.isConnected {
-		return
+		return nil
 	}
 
 	privIP, broadIP, err := GetPrivateIPAndBroadcastAddr()
 	if err != nil {
-		// Not much we can do here.
-		// A network protocol can't work without a network.
-		log.Fatal(err)
+		return err
 	}
 
-	conn.localAddr = &net.UDPAddr{
+	port := conn.Port
+	if port == 0 {
+		port = BroadcastPort
+	}
+
+	localAddr := &net.UDPAddr{
 		IP:   privIP,
-		Port: BroadcastPort,
+		Port: port,
 	}
 	broadcastAddr, err := net.ResolveUDPAddr(
 		"udp",
-		fmt.Sprintf("%s:%d", broadIP, BroadcastPort),
+		fmt.Sprintf("%s:%d", broadIP, port),
 	)
 	if err != nil {
-		// Not much we can do here.
-		// If a UDP address can't be dialed, the protocol can't work.
-		log.Fatal(err)
+		return fmt.Errorf("resolving broadcast address: %w", err)
 	}
-	conn.broadcastAddr = broadcastAddr
 
-	sendConn, err := net.DialUDP("udp", nil, conn.broadcastAddr)
+	sendConn, err := net.DialUDP("udp", nil, broadcastAddr)
 	if err != nil {
-		// Not much we can do here.
-		// If a UDP address can't be dialed, the protocol can't work.
-		log.Fatal(err)
+		return fmt.Errorf("dialing broadcast address: %w", err)
 	}
-	conn.sendConn = sendConn
 
-	readAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", BroadcastPort))
-	if err != nil {
-		log.Fatal(err)
-	}
-	readUdpConn, err := net.ListenUDP("udp", readAddr)
+	readConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
 	if err != nil {
-		// Not much we can do here.
-		// If a UDP address can't be dialed, the protocol can't work.
-		panic(err)
+		sendConn.Close()
+		return fmt.Errorf("listening to broadcast port: %w", err)
 	}
-	conn.readConn = readUdpConn
 
+	conn.localAddr = localAddr
+	conn.broadcastAddr = broadcastAddr
+	conn.sendConn = sendConn
+	conn.readConn = readConn
 	conn.isConnected = true
+
+	return nil
 }
 
 func (conn UDPBroadcastConn) LocalAddr() *net.UDPAddr {
@@ -78,10 +81,17 @@ func (conn UDPBroadcastConn) Write(b []byte) (int, error) {
 }
 
 func (conn UDPBroadcastConn) Read(b []byte) (int, *net.UDPAddr, error) {
-	conn.readConn.SetReadDeadline(time.Now().Add(connReadTimeout))
 	return conn.readConn.ReadFromUDP(b)
 }
 
+func (conn UDPBroadcastConn) SetReadDeadline(t time.Time) error {
+	if conn.readConn == nil {
+		return net.ErrClosed
+	}
+
+	return conn.readConn.SetReadDeadline(t)
+}
+
 func (conn *UDPBroadcastConn) Close() {
 	if !conn.isConnected {
 		return
@@ -101,41 +111,46 @@ func (conn *UDPBroadcastConn) Close() {
 // UDPUnicastConn is an implementation of the UnicastConn interface that uses
 // the UDP connection-less protocol to send and receive messages.
 type UDPUnicastConn struct {
+	// Port is the port the unicast messages are read from and sent from.
+	// Zero means UnicastPort.
+	Port int
+
 	localAddr   *net.UDPAddr
 	isConnected bool
 	readConn    *net.UDPConn
 }
 
-func (conn *UDPUnicastConn) Connect() {
+// Connect opens the connection to send and receive unicast messages in the
+// private network. The protocol can't work without it, so the error should be
+// treated as fatal.
+func (conn *UDPUnicastConn) Connect() error {
 	if conn.isConnected {
-		return
+		return nil
 	}
 
 	privIP, _, err := GetPrivateIPAndBroadcastAddr()
 	if err != nil {
-		// Not much we can do here.
-		// A network protocol can't work without a network.
-		log.Fatal(err)
+		return err
 	}
 
-	conn.localAddr = &net.UDPAddr{
-		IP:   privIP,
-		Port: BroadcastPort,
+	port := conn.Port
+	if port == 0 {
+		port = UnicastPort
 	}
 
-	readAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", UnicastPort))
-	if err != nil {
-		log.Fatal(err)
-	}
-	readUdpConn, err := net.ListenUDP("udp", readAddr)
+	readConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
 	if err != nil {
-		// Not much we can do here.
-		// If a UDP address can't be dialed, the protocol can't work.
-		panic(err)
+		return fmt.Errorf("listening to unicast port: %w", err)
 	}
-	conn.readConn = readUdpConn
 
+	conn.localAddr = &net.UDPAddr{
+		IP:   privIP,
+		Port: port,
+	}
+	conn.readConn = readConn
 	conn.isConnected = true
+
+	return nil
 }
 
 func (conn UDPUnicastConn) LocalAddr() *net.UDPAddr {
@@ -143,19 +158,23 @@ func (conn UDPUnicastConn) LocalAddr() *net.UDPAddr {
 }
 
 func (conn UDPUnicastConn) Write(b []byte, to *net.UDPAddr) (int, error) {
-	sendConn, err := net.DialUDP("udp", nil, to)
-	if err != nil {
-		return 0, err
-	}
-
-	return sendConn.Write(b)
+	// Writing from the listening connection sends the message from the unicast
+	// port, without dialing a new connection for each message.
+	return conn.readConn.WriteToUDP(b, to)
 }
 
 func (conn UDPUnicastConn) Read(b []byte) (int, *net.UDPAddr, error) {
-	conn.readConn.SetReadDeadline(time.Now().Add(connReadTimeout))
 	return conn.readConn.ReadFromUDP(b)
 }
 
+func (conn UDPUnicastConn) SetReadDeadline(t time.Time) error {
+	if conn.readConn == nil {
+		return net.ErrClosed
+	}
+
+	return conn.readConn.SetReadDeadline(t)
+}
+
 func (conn *UDPUnicastConn) Close() {
 	if !conn.isConnected {
 		return
@@ -0,0 +1,166 @@
+package prototari
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Config returns the current configuration.
+func (m *CommsManager) Config() Config {
+	m.configMutex.RLock()
+	defer m.configMutex.RUnlock()
+
+	return m.config
+}
+
+// UpdateConfig applies a new configuration without stopping the
+// communications, so the registered peers are kept:
+//
+//   - A new BroadcastInterval applies to the ongoing wait for the next
+//     broadcast.
+//   - If MaxPeers is reduced below the number of registered peers, the excess
+//     peers are chosen by the EvictionPolicy among those that aren't pinned,
+//     and evicted.
+//   - The registered peers the new admission filter rejects are evicted.
+//   - The rate limits start over with full buckets.
+//   - A new SendQueueCapacity applies to the send queues created from then on.
+//   - The Logger is replaced for every log from then on.
+//   - A new FailureDetector judges the peers from then on, starting from the
+//     time each was last heard from. A new PhiAccrualDetector replacing
+//     another only changes its parameters, so the intervals observed are kept.
+//
+// The evicted peers are sent the disconnect message, so that they unregister
+// this computer too.
+//
+// The connections aren't opened again, so changing the ports returns
+// ErrRebindRequired. The Observer can't be replaced; the new one is ignored.
+// As when making the manager, the parameters left at zero, other than MaxPeers
+// and BroadcastInterval, are set to their defaults when zero isn't valid for
+// them. An invalid configuration is rejected with the validation error,
+// leaving the current one in place.
+func (m *CommsManager) UpdateConfig(config Config) error {
+	config = config.withDefaults()
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	m.configMutex.Lock()
+
+	old := m.config
+	if config.BroadcastPort != old.BroadcastPort || config.UnicastPort != old.UnicastPort {
+		m.configMutex.Unlock()
+		return fmt.Errorf("changing the ports: %w", ErrRebindRequired)
+	}
+
+	config.Observer = old.Observer
+	config.FailureDetector = keptFailureDetector(old.FailureDetector, config.FailureDetector)
+	detectorChanged := m.failureDetector(config) != m.failureDetector(old)
+	m.config = config
+	m.logHandler.swap(config.logger().Handler())
+	m.thresholdDetector.setMaxMissed(config.MaxMissedHeartbeats)
+
+	if config.RateLimits != old.RateLimits {
+		m.outboundLimits.Store(makeOutboundLimits(config.RateLimits, time.Now()))
+		m.inboundLimiter.setLimit(config.RateLimits.InboundPackets)
+	}
+
+	m.configMutex.Unlock()
+
+	if detectorChanged {
+		m.seedFailureDetector(m.failureDetector(config))
+	}
+
+	signal(m.configChanged)
+	m.logger.Info("Configuration updated")
+
+	m.evictPeers(EvictionNotAdmitted, func(peers []Peer) []Peer {
+		var rejected []Peer
+		for _, peer := range peers {
+			if !config.admits(peer.IP) {
+				rejected = append(rejected, peer)
+			}
+		}
+		return rejected
+	})
+	m.evictPeers(EvictionExcess, func(peers []Peer) []Peer {
+		unpinned := slices.DeleteFunc(slices.Clone(peers), func(peer Peer) bool {
+			return config.pinned(peer.IP)
+		})
+		return config.EvictionPolicy.victims(unpinned, len(peers)-config.MaxPeers)
+	})
+
+	return nil
+}
+
+// keptFailureDetector returns the failure detector to use instead of next when
+// replacing current: current with the parameters of next if both are phi
+// accrual detectors, so that the intervals observed aren't lost when the same
+// detector is configured again, or next otherwise.
+func keptFailureDetector(current, next FailureDetector) FailureDetector {
+	currentPhi, ok := current.(*PhiAccrualDetector)
+	if !ok {
+		return next
+	}
+	nextPhi, ok := next.(*PhiAccrualDetector)
+	if !ok || nextPhi == currentPhi {
+		return next
+	}
+
+	currentPhi.setParams(nextPhi.params)
+	return currentPhi
+}
+
+// seedFailureDetector tells a failure detector that was just installed when
+// the registered peers were last heard from, so that it can judge the ones
+// that stay silent.
+func (m *CommsManager) seedFailureDetector(detector FailureDetector) {
+	m.peersMutex.RLock()
+	defer m.peersMutex.RUnlock()
+
+	for _, peer := range m.peers {
+		detector.Heard(peer.IP, peer.LastSeen)
+	}
+}
+
+// evictPeers unregisters the peers chosen among the registered ones, and sends
+// them the disconnect message. It returns the evicted peers.
+func (m *CommsManager) evictPeers(reason EvictionReason, choose func(peers []Peer) []Peer) []Peer {
+	m.peersMutex.Lock()
+
+	victims := choose(m.sortedPeers())
+	if len(victims) == 0 {
+		m.peersMutex.Unlock()
+		return nil
+	}
+
+	for _, peer := range victims {
+		delete(m.peers, peerKey(peer.IP))
+	}
+	m.publishPeers()
+	m.peersMutex.Unlock()
+
+	for _, peer := range victims {
+		m.dismiss(peer, reason)
+	}
+
+	return victims
+}
+
+// dismiss notifies the eviction of a peer that was unregistered, and sends it
+// the disconnect message.
+// It must be called without holding the peers mutex.
+func (m *CommsManager) dismiss(peer Peer, reason EvictionReason) {
+	m.notifyUnregistered(peer, reason)
+
+	err := m.send(context.Background(), []byte(disconnectMessage), m.peerAddress(peer.IP), PriorityControl)
+	if err != nil {
+		m.logger.Debug(
+			"Couldn't send disconnect message to evicted peer",
+			slog.String("peer", peer.IP.String()),
+			slog.Any("error", err),
+		)
+	}
+}
@@ -0,0 +1,134 @@
+package prototari
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	var (
+		localAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.10"), Port: UnicastPort}
+		ips       = []net.IP{
+			net.ParseIP("192.168.0.20"),
+			net.ParseIP("192.168.0.21"),
+			net.ParseIP("192.168.0.22"),
+		}
+		makeManagerWithPeers = func(unicConn *fakeUnicastConn) *CommsManager {
+			config := makeTestingConfig()
+			config.MaxPeers = len(ips)
+
+			var (
+				broadConn = fakeBroadcastConn{localAddr: localAddr}
+				manager   = MakeManager(&broadConn, unicConn, config)
+				now       = time.Now()
+			)
+
+			// The first peer is the least recently seen, and the last one the
+			// most recently registered
+			for i, IP := range ips {
+				peer := MakePeer(IP)
+				peer.LastSeen = now.Add(time.Duration(i) * time.Second)
+				manager.registerPeer(peer)
+			}
+
+			return manager
+		}
+		registeredIPs = func(manager *CommsManager) []string {
+			var registered []string
+			for _, IP := range ips {
+				if manager.hasPeer(IP) {
+					registered = append(registered, IP.String())
+				}
+			}
+			return registered
+		}
+	)
+
+	t.Run("Reducing MaxPeers evicts the least recently seen peers", func(t *testing.T) {
+		manager := makeManagerWithPeers(&fakeUnicastConn{localAddr: localAddr})
+
+		config := manager.Config()
+		config.MaxPeers = 1
+
+		assert.Nil(t, manager.UpdateConfig(config))
+		assert.Equal(t, []string{"192.168.0.22"}, registeredIPs(manager))
+		assert.Equal(t, uint64(2), manager.Metrics().PeersEvicted)
+	})
+
+	t.Run("Reducing MaxPeers can evict the newest peers", func(t *testing.T) {
+		manager := makeManagerWithPeers(&fakeUnicastConn{localAddr: localAddr})
+
+		config := manager.Config()
+		config.MaxPeers = 2
+		config.EvictionPolicy = EvictNewest
+
+		assert.Nil(t, manager.UpdateConfig(config))
+		assert.Equal(t, []string{"192.168.0.20", "192.168.0.21"}, registeredIPs(manager))
+	})
+
+	t.Run("Reducing MaxPeers doesn't evict the pinned peers", func(t *testing.T) {
+		manager := makeManagerWithPeers(&fakeUnicastConn{localAddr: localAddr})
+
+		config := manager.Config()
+		config.MaxPeers = 1
+		config.PinnedPeers = IPList{ips[0]}
+
+		assert.Nil(t, manager.UpdateConfig(config))
+		assert.Equal(t, []string{"192.168.0.20"}, registeredIPs(manager))
+	})
+
+	t.Run("Peers rejected by the new admission filter are told to disconnect", func(t *testing.T) {
+		var (
+			writeCh  = make(chan fakeMsgRecord, 1)
+			written  = make(chan fakeMsgRecord, 1)
+			unicConn = fakeUnicastConn{
+				localAddr: localAddr,
+				writeChan: writeCh,
+				written:   written,
+			}
+			manager = makeManagerWithPeers(&unicConn)
+		)
+
+		manager.Start()
+		defer manager.Stop()
+
+		config := manager.Config()
+		config.Admit = func(IP net.IP) bool { return !IP.Equal(ips[1]) }
+
+		assert.Nil(t, manager.UpdateConfig(config))
+		assert.Equal(t, []string{"192.168.0.20", "192.168.0.22"}, registeredIPs(manager))
+
+		select {
+		case msg := <-writeCh:
+			assert.Equal(t, []byte(disconnectMessage), msg.Payload)
+			assert.True(t, ips[1].Equal(msg.To.IP))
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Disconnect message not sent")
+		}
+
+		assert.ErrorIs(t, manager.registerPeer(MakePeer(ips[1])), ErrNotAdmitted)
+	})
+
+	t.Run("Changing the ports is rejected", func(t *testing.T) {
+		manager := makeManagerWithPeers(&fakeUnicastConn{localAddr: localAddr})
+
+		config := manager.Config()
+		config.UnicastPort = 31450
+
+		assert.ErrorIs(t, manager.UpdateConfig(config), ErrRebindRequired)
+		assert.Equal(t, UnicastPort, manager.Config().UnicastPort)
+	})
+
+	t.Run("Invalid configurations are rejected", func(t *testing.T) {
+		manager := makeManagerWithPeers(&fakeUnicastConn{localAddr: localAddr})
+
+		config := manager.Config()
+		config.MaxPeers = 0
+
+		assert.NotNil(t, manager.UpdateConfig(config))
+		assert.Len(t, registeredIPs(manager), len(ips))
+	})
+}